@@ -0,0 +1,41 @@
+package main
+
+import "net/http"
+
+// reindexSummary is what POST /admin/reindex sends back, how much the rebuilt indexes hold
+type reindexSummary struct {
+	Pages       int `json:"pages"`
+	Tags        int `json:"tags"`
+	LinkTargets int `json:"link_targets"`
+}
+
+// reindexHandler rebuilds every cached index from the store on POST /admin/reindex
+// This is for when pages have been changed behind the wiki's back, like files restored from a backup,
+// which the indexes have no way of noticing. It only rebuilds caches, so it's safe to call at any time
+func (s *wikiServer) reindexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	titles, err := s.listPages(r.Context())
+	if err != nil {
+		writeJSONInternalError(w, r, err)
+		return
+	}
+	tagIndex, err := s.tags.rebuild(r.Context())
+	if err != nil {
+		writeJSONInternalError(w, r, err)
+		return
+	}
+	linkIndex, err := s.links.rebuild(r.Context())
+	if err != nil {
+		writeJSONInternalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reindexSummary{
+		Pages:       len(titles),
+		Tags:        len(tagIndex),
+		LinkTargets: len(linkIndex),
+	})
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// apiPagePath matches /api/pages/<title> with the same title rules as validPath, and apiBacklinksPath /api/backlinks/<title>
+var (
+	apiPagePath      = regexp.MustCompile("^/api/pages/(" + titlePattern + ")$")
+	apiBacklinksPath = regexp.MustCompile("^/api/backlinks/(" + titlePattern + ")$")
+)
+
+// apiPage is the JSON representation of a page
+// The body is sent as a string rather than the []byte in Page, which encoding/json would turn into base64
+type apiPage struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+// apiError is the JSON object sent back whenever an API request fails
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// writeJSON sends v as the JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("writing JSON response", "err", err)
+	}
+}
+
+// writeJSONError sends an apiError with the given status code
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiError{Error: msg})
+}
+
+// apiPagesHandler handles GET /api/pages, which returns the title of every page as a JSON array
+// DELETE deletes many pages at once, see apiBulkDelete
+func (s *wikiServer) apiPagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		if *readOnly {
+			writeJSONError(w, http.StatusForbidden, readOnlyMessage)
+			return
+		}
+		if !requireAuth(w, r) {
+			return
+		}
+		s.apiBulkDelete(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	titles, err := s.listPages(r.Context())
+	if err != nil {
+		writeJSONInternalError(w, r, err)
+		return
+	}
+	// An empty wiki should be [] rather than null
+	if titles == nil {
+		titles = []string{}
+	}
+	writeJSON(w, http.StatusOK, titles)
+}
+
+// bulkDeleteResult is what DELETE /api/pages sends back
+// Errors maps the title of each page that couldn't be deleted to why
+type bulkDeleteResult struct {
+	Deleted []string          `json:"deleted"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// apiBulkDelete deletes every page matching ?prefix= or the path.Match pattern in ?glob=, for clearing out spam
+// One of them has to be given, so a bare DELETE can't take everything with it, and so does confirm=true.
+// A page failing to delete doesn't stop the rest, it's reported in Errors without the details, which are logged
+func (s *wikiServer) apiBulkDelete(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix, glob := q.Get("prefix"), q.Get("glob")
+	if (prefix == "") == (glob == "") {
+		writeJSONError(w, http.StatusBadRequest, "give exactly one of prefix or glob")
+		return
+	}
+	if _, err := path.Match(glob, ""); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid glob pattern")
+		return
+	}
+	if q.Get("confirm") != "true" {
+		writeJSONError(w, http.StatusBadRequest, "deleting pages needs confirm=true")
+		return
+	}
+	titles, err := s.listPages(r.Context())
+	if err != nil {
+		writeJSONInternalError(w, r, err)
+		return
+	}
+	slices.Sort(titles)
+	result := bulkDeleteResult{Deleted: []string{}}
+	for _, title := range titles {
+		if prefix != "" && !strings.HasPrefix(title, prefix) {
+			continue
+		}
+		if glob != "" {
+			if ok, _ := path.Match(glob, title); !ok {
+				continue
+			}
+		}
+		if err := s.deletePage(r.Context(), title); err != nil {
+			logRequestError(r, fmt.Errorf("deleting %s: %w", title, err))
+			if result.Errors == nil {
+				result.Errors = make(map[string]string)
+			}
+			result.Errors[title] = "could not be deleted"
+			continue
+		}
+		result.Deleted = append(result.Deleted, title)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// apiPageHandler handles a single page on /api/pages/<title>
+// GET returns the page, PUT creates or replaces it from a JSON body, and POST only creates it.
+// PUT and POST need the same credentials as saving through the edit form.
+// Unlike viewHandler, a missing page is a 404 rather than a redirect to the edit form
+func (s *wikiServer) apiPageHandler(w http.ResponseWriter, r *http.Request) {
+	m := apiPagePath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	title := m[1]
+	if err := validateTitle(title); err != nil {
+		if errors.Is(err, errTitleTooLong) {
+			writeJSONError(w, http.StatusBadRequest, titleTooLongMessage())
+			return
+		}
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		p, err := s.loadPage(r.Context(), title)
+		if errors.Is(err, os.ErrNotExist) {
+			writeJSONError(w, http.StatusNotFound, "page not found")
+			return
+		}
+		if err != nil {
+			writeJSONInternalError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, apiPage{Title: p.Title, Body: string(p.Body)})
+	case http.MethodPut, http.MethodPost:
+		if *readOnly {
+			writeJSONError(w, http.StatusForbidden, readOnlyMessage)
+			return
+		}
+		if !requireJSON(w, r) {
+			return
+		}
+		// The same limit as saving through the edit form, so the API isn't a way around it
+		if retryAfter, ok := s.saves.allow(r); !ok {
+			w.Header().Set("Retry-After", retryAfter)
+			writeJSONError(w, http.StatusTooManyRequests, "too many requests")
+			return
+		}
+		if !requireAuth(w, r) {
+			return
+		}
+		s.apiSavePage(w, r, title)
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// requireJSON turns away a request whose body isn't sent as application/json with a 415, reporting whether it's allowed through
+// A form on another site can only send text/plain, urlencoded or multipart bodies without the browser
+// asking first, so insisting on JSON keeps those from saving pages with the reader's credentials
+func requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "body must be sent as application/json")
+		return false
+	}
+	return true
+}
+
+// apiSavePage saves the page sent in a PUT or POST to /api/pages/<title>
+// Creating a page is a 201 with its URL in Location, and replacing one with PUT is a 200.
+// POST never replaces a page, if the title is already taken it's a 409 and nothing is written
+func (s *wikiServer) apiSavePage(w http.ResponseWriter, r *http.Request, title string) {
+	var in apiPage
+	r.Body = http.MaxBytesReader(w, r.Body, *maxSize)
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "page is too large")
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	old, err := s.loadPage(r.Context(), title)
+	exists := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		writeJSONInternalError(w, r, err)
+		return
+	}
+	if exists && r.Method == http.MethodPost {
+		writeJSONError(w, http.StatusConflict, "page already exists")
+		return
+	}
+	// With -ignorecase the page may have been found under different case, replace that one rather than adding another
+	if exists {
+		title = old.Title
+	}
+
+	if !exists {
+		if err := s.checkPageLimit(r.Context(), title); errors.Is(err, errTooManyPages) {
+			writeJSONError(w, http.StatusInsufficientStorage, errTooManyPages.Error())
+			return
+		} else if err != nil {
+			writeJSONInternalError(w, r, err)
+			return
+		}
+	}
+	p := &Page{Title: title, Body: []byte(in.Body)}
+	if err := s.savePage(r.Context(), p); err != nil {
+		writeJSONInternalError(w, r, err)
+		return
+	}
+	status := http.StatusOK
+	if !exists {
+		w.Header().Set("Location", pathTo("/api/pages/"+title))
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, apiPage{Title: p.Title, Body: string(p.Body)})
+}
+
+// apiSearchHandler handles GET /api/search?q=, the JSON version of /search
+// limit caps how many results come back. An empty query, like a search with no matches, is an empty array
+func (s *wikiServer) apiSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			writeJSONError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+	}
+	results := []SearchResult{}
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		found, err := s.searchPages(r.Context(), q)
+		if err != nil {
+			writeJSONInternalError(w, r, err)
+			return
+		}
+		results = append(results, found...)
+	}
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// apiBacklinksHandler handles GET /api/backlinks/<title>, the titles of the pages linking to a page as a JSON array
+// A page nothing links to is an empty array, and it's only a 404 if the page itself doesn't exist
+func (s *wikiServer) apiBacklinksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	m := apiBacklinksPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err := validateTitle(m[1]); errors.Is(err, errTitleTooLong) {
+		writeJSONError(w, http.StatusBadRequest, titleTooLongMessage())
+		return
+	}
+	if !s.pageExists(r.Context(), m[1]) {
+		writeJSONError(w, http.StatusNotFound, "page not found")
+		return
+	}
+	titles, err := s.backlinks(r.Context(), m[1])
+	if err != nil {
+		writeJSONInternalError(w, r, err)
+		return
+	}
+	if titles == nil {
+		titles = []string{}
+	}
+	writeJSON(w, http.StatusOK, titles)
+}
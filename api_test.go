@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Only JSON bodies can save pages through the API, so a cross-site form posting text/plain can't
+func TestAPISaveNeedsJSON(t *testing.T) {
+	_, h := newTestWiki(t)
+
+	for _, contentType := range []string{"text/plain", "application/x-www-form-urlencoded", ""} {
+		r := httptest.NewRequest("POST", "/api/pages/Forged", strings.NewReader(`{"body": "spam"}`))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		if w := serve(h, r); w.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("POST as %q got %d, want 415", contentType, w.Code)
+		}
+	}
+	if w := serve(h, httptest.NewRequest("GET", "/api/pages/Forged", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("refused page was saved, GET got %d", w.Code)
+	}
+
+	r := jsonRequest("POST", "/api/pages/Real", `{"body": "hello"}`)
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if w := serve(h, r); w.Code != http.StatusCreated {
+		t.Errorf("POST as JSON got %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// The templates and static files are built into the binary, so it can be deployed on its own
+// tmpl/ and static/ on disk are only read with -dev, or when -tmpldir points somewhere
+//
+//go:embed tmpl/*/*.html
+var embeddedTemplates embed.FS
+
+//go:embed static
+var embeddedStatic embed.FS
+
+// devTemplateDir and devStaticDir are where -dev reads the templates and static files from,
+// the directories they're embedded from
+const (
+	devTemplateDir = "tmpl"
+	devStaticDir   = "static"
+)
+
+// diskTemplateDir returns the directory templates are read from, or "" if the embedded ones are used
+func diskTemplateDir() string {
+	if *templateDir != "" {
+		return *templateDir
+	}
+	if *dev {
+		return devTemplateDir
+	}
+	return ""
+}
+
+// Each theme is a complete set of templates in its own directory under tmpl/, like tmpl/default/
+// -theme picks which one is parsed, and activeTheme is the one actually in use once chooseTheme has checked it
+const defaultTheme = "default"
+
+var (
+	templateTheme = flag.String("theme", defaultTheme, "set of templates to use, the name of a directory under tmpl/ or -tmpldir")
+	activeTheme   = defaultTheme
+)
+
+// themeRoot returns the filesystem holding every theme's directory
+func themeRoot() fs.FS {
+	if dir := diskTemplateDir(); dir != "" {
+		return os.DirFS(dir)
+	}
+	sub, _ := fs.Sub(embeddedTemplates, "tmpl")
+	return sub
+}
+
+// templateFS returns the filesystem the templates are parsed from, the active theme's directory
+func templateFS() fs.FS {
+	sub, _ := fs.Sub(themeRoot(), activeTheme)
+	return sub
+}
+
+// missingTemplates returns the templates the given theme doesn't have
+func missingTemplates(theme string) []string {
+	var missing []string
+	for _, name := range templateNames {
+		if _, err := fs.Stat(themeRoot(), theme+"/"+name+".html"); errors.Is(err, fs.ErrNotExist) {
+			missing = append(missing, name+".html")
+		}
+	}
+	return missing
+}
+
+// chooseTheme sets activeTheme from -theme
+// A theme missing any of the templates can't be rendered, so the default is used in its place with a warning
+// rather than having pages fail one by one. It's only an error if the default itself is incomplete
+func chooseTheme() error {
+	activeTheme = *templateTheme
+	if !fs.ValidPath(activeTheme) || strings.Contains(activeTheme, "/") {
+		return fmt.Errorf("invalid theme %q", activeTheme)
+	}
+	if _, err := fs.Stat(themeRoot(), activeTheme); err != nil && activeTheme != defaultTheme {
+		slog.Warn("theme not found, using the default", "theme", activeTheme, "default", defaultTheme)
+		activeTheme = defaultTheme
+	}
+	if missing := missingTemplates(activeTheme); len(missing) > 0 && activeTheme != defaultTheme {
+		slog.Warn("theme is missing templates, using the default", "theme", activeTheme, "missing", strings.Join(missing, ", "), "default", defaultTheme)
+		activeTheme = defaultTheme
+	}
+	if missing := missingTemplates(activeTheme); len(missing) > 0 {
+		return fmt.Errorf("theme %q is missing %s", activeTheme, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// staticFS returns the filesystem /static/ is served from
+// With -dev it's the directory on disk, so changes to the stylesheet show up without a rebuild
+func staticFS() fs.FS {
+	if *dev {
+		return os.DirFS(devStaticDir)
+	}
+	sub, _ := fs.Sub(embeddedStatic, "static")
+	return sub
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maxUpload caps the size of a single attachment, and of a zip uploaded to /import
+var maxUpload = flag.Int64("maxupload", 10<<20, "maximum size in bytes of an uploaded attachment or import archive")
+
+// attachmentPath matches /attachments/<title>/<file>
+// The filename can't contain a slash, so a nested title is everything before the last one
+var attachmentPath = regexp.MustCompile("^/attachments/(" + titlePattern + ")/([^/]+)$")
+
+// unsafeFilenameChars matches everything that isn't allowed in an attachment's filename
+var unsafeFilenameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// errInvalidFilename is returned when an uploaded file's name has nothing usable left after sanitizing
+var errInvalidFilename = errors.New("invalid attachment filename")
+
+// attachmentDir returns the directory the attachments of a page are kept in
+// Attachments are always kept on disk under -datadir, whichever store the pages are in
+func attachmentDir(title string) string {
+	return filepath.Join(*dataDir, "attachments", filepath.FromSlash(title))
+}
+
+// sanitizeFilename turns the name a browser sent for an upload into one that is safe to save to disk
+// Any directories are dropped, anything other than letters, numbers, dots, dashes and underscores
+// becomes an underscore, and leading dots are removed so the file can't be hidden or be . or ..
+func sanitizeFilename(name string) (string, error) {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = unsafeFilenameChars.ReplaceAllString(name, "_")
+	name = strings.TrimLeft(name, ".")
+	if name == "" {
+		return "", errInvalidFilename
+	}
+	return name, nil
+}
+
+// listAttachments returns the filenames of every attachment of a page
+func listAttachments(title string) ([]string, error) {
+	entries, err := os.ReadDir(attachmentDir(title))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// uploadHandler saves a file sent in the file field of a multipart form as an attachment of the page
+// Uploads bigger than -maxupload are rejected with a 413. An existing attachment with the same name is replaced
+func (s *wikiServer) uploadHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, *maxUpload)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "attachment is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validCSRF(r) {
+		http.Error(w, "invalid or missing CSRF token", http.StatusForbidden)
+		return
+	}
+	if !s.pageExists(r.Context(), title) {
+		notFound(w, r)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "no file was uploaded", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	name, err := sanitizeFilename(header.Filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dir := attachmentDir(title)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	out, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if _, err := io.Copy(out, file); err != nil {
+		out.Close()
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if err := out.Close(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	http.Redirect(w, r, pathTo("/view/"+title), http.StatusFound)
+}
+
+// attachmentHandler serves an attachment on /attachments/<title>/<file>
+// http.ServeContent picks the content type from the extension, or by sniffing the file if that doesn't work.
+// The sandbox policy stops an uploaded HTML file from running scripts as if it were part of the wiki
+func attachmentHandler(w http.ResponseWriter, r *http.Request) {
+	m := attachmentPath.FindStringSubmatch(r.URL.Path)
+	if m == nil || validateTitle(m[1]) != nil {
+		notFound(w, r)
+		return
+	}
+	name, err := sanitizeFilename(m[2])
+	if err != nil || name != m[2] {
+		notFound(w, r)
+		return
+	}
+	f, err := os.Open(filepath.Join(attachmentDir(m[1]), name))
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		notFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
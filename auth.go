@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Where the usernames and passwords allowed to make changes come from
+// -auth gives a single user:password pair and -authfile names a file with one user:password pair per line.
+// Both can be used together. If neither is set the wiki stays open for anyone to edit
+var (
+	authUser = flag.String("auth", "", "user:password allowed to edit, save and delete pages")
+	authFile = flag.String("authfile", "", "file of user:password lines allowed to edit, save and delete pages")
+)
+
+// credentials maps each username to its password, loaded by loadCredentials
+var credentials map[string]string
+
+// loadCredentials reads the users allowed to make changes from -auth and -authfile
+// Blank lines and lines starting with # in the file are ignored
+func loadCredentials() error {
+	creds := make(map[string]string)
+	add := func(pair string) error {
+		user, pass, ok := strings.Cut(pair, ":")
+		if !ok || user == "" {
+			return fmt.Errorf("credentials must be user:password, got %q", pair)
+		}
+		creds[user] = pass
+		return nil
+	}
+	if *authUser != "" {
+		if err := add(*authUser); err != nil {
+			return err
+		}
+	}
+	if *authFile != "" {
+		f, err := os.Open(*authFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if err := add(line); err != nil {
+				return fmt.Errorf("%s: %w", *authFile, err)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return err
+		}
+	}
+	credentials = creds
+	return nil
+}
+
+// authorized checks the request's basic auth credentials against the configured users
+// When no users are configured everyone is authorized. The password is compared in constant time
+func authorized(r *http.Request) bool {
+	if len(credentials) == 0 {
+		return true
+	}
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	want, ok := credentials[user]
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(pass), []byte(want)) == 1
+}
+
+// requireAuth sends a 401 asking for credentials and returns false if the request isn't authorized
+func requireAuth(w http.ResponseWriter, r *http.Request) bool {
+	if authorized(r) {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="wiki", charset="UTF-8"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+// authMiddleware only lets requests with valid basic auth credentials through to h
+func authMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requireAuth(w, r) {
+			h.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"slices"
+)
+
+// buildBacklinkIndex loads every page and collects the wiki links in them, for the links index,
+// which maps every title to the titles of the pages that link to it with [Title]
+// A link to a page that doesn't exist is still recorded, so it shows up once the page is created
+func (s *wikiServer) buildBacklinkIndex(ctx context.Context) (map[string][]string, error) {
+	titles, err := s.listPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string][]string)
+	for _, title := range titles {
+		p, err := s.loadPage(ctx, title)
+		if err != nil {
+			continue
+		}
+		for _, target := range linkedTitles(p) {
+			if target != title && !slices.Contains(index[target], title) {
+				index[target] = append(index[target], title)
+			}
+		}
+	}
+	for _, titles := range index {
+		slices.Sort(titles)
+	}
+	return index, nil
+}
+
+// backlinks returns the titles of the pages linking to the given page, in alphabetical order
+// A page linking to itself doesn't count
+func (s *wikiServer) backlinks(ctx context.Context, title string) ([]string, error) {
+	index, err := s.links.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return index[title], nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"regexp"
+)
+
+// basePath is the URL path the wiki is mounted under, for running it behind a proxy alongside other things
+// "" serves it from the root as usual
+var basePath = flag.String("basepath", "", "URL path the wiki is served under behind a proxy, e.g. /wiki")
+
+// validBasePath is what -basepath can be, one or more path segments with a leading slash and no trailing one
+// The characters are kept to ones that never need escaping, so it can go into links and headers as is
+var validBasePath = regexp.MustCompile(`^(?:/[a-zA-Z0-9._~-]+)+$`)
+
+// pathTo returns the URL for p, a path on the wiki starting with /, under -basepath
+// Every link and redirect the wiki makes to itself goes through this, or {{base}} in templates,
+// and so do cookie paths so the wiki's cookies aren't sent to everything else on the host
+func pathTo(p string) string {
+	return *basePath + p
+}
+
+// mountBasePath serves h under -basepath
+// The prefix is stripped before h sees the request, so the routes, validPath and the handlers
+// all work on paths as if the wiki were at the root. Anything outside the prefix is a 404,
+// and the prefix on its own is redirected to the prefix with a slash, which is the wiki's /
+func mountBasePath(h http.Handler) http.Handler {
+	if *basePath == "" {
+		return h
+	}
+	mux := http.NewServeMux()
+	mux.Handle(*basePath+"/", http.StripPrefix(*basePath, h))
+	mux.Handle(*basePath, http.RedirectHandler(*basePath+"/", http.StatusMovedPermanently))
+	return mux
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBasePath(t *testing.T) {
+	setFlag(t, basePath, "/wiki")
+	s, h := newTestWiki(t)
+	addPage(t, s, "Home", "see [Other]")
+
+	tests := []struct {
+		path     string
+		code     int
+		location string
+	}{
+		{"/wiki/view/Home", http.StatusOK, ""},
+		{"/wiki/view/Other", http.StatusFound, "/wiki/edit/Other"},
+		{"/wiki/view/Home/", http.StatusMovedPermanently, "/wiki/view/Home"},
+		{"/wiki/pages", http.StatusOK, ""},
+		{"/wiki/healthz", http.StatusOK, ""},
+		{"/wiki", http.StatusMovedPermanently, "/wiki/"},
+		{"/view/Home", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := serve(h, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.code || w.Header().Get("Location") != tt.location {
+			t.Errorf("GET %s got %d to %q, want %d to %q", tt.path, w.Code, w.Header().Get("Location"), tt.code, tt.location)
+		}
+	}
+
+	w := serve(h, httptest.NewRequest("GET", "/wiki/view/Home", nil))
+	for _, link := range []string{`href="/wiki/edit/Home"`, `href="/wiki/view/Other" class="missing"`} {
+		if !strings.Contains(w.Body.String(), link) {
+			t.Errorf("view has no %s", link)
+		}
+	}
+
+	// The cookies belong to the wiki, not to everything else on the host
+	responses := map[string]*httptest.ResponseRecorder{
+		"csrf":  w,
+		"theme": serve(h, httptest.NewRequest("GET", "/wiki/theme?set=dark", nil)),
+		"lang":  serve(h, httptest.NewRequest("GET", "/wiki/lang?set=de", nil)),
+	}
+	for name, w := range responses {
+		cookies := w.Result().Cookies()
+		if len(cookies) == 0 {
+			t.Errorf("%s response set no cookie", name)
+		}
+		for _, c := range cookies {
+			if c.Path != "/wiki/" {
+				t.Errorf("%s cookie %s has path %q, want /wiki/", name, c.Name, c.Path)
+			}
+		}
+	}
+}
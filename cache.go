@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pageETag computes a strong ETag for a page from its body
+// Half of a SHA-256 is plenty to tell two versions of a page apart
+func pageETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// started is when the server started, and startTag changes with it, for the ETags of responses made from the templates
+// A restart may have brought new templates, which can change the response without anything else changing
+var (
+	started  = time.Now()
+	startTag = strconv.FormatInt(started.UnixNano(), 36)
+)
+
+// viewETag computes the ETag of a page's HTML view
+// The view is rendered from much more than the body, so everything else it shows goes into the tag too:
+// which of the pages it links to exist, its attachments and backlinks, and what differs from one reader to the next,
+// their theme, language and CSRF token. The query string picks things like the print view.
+// The attachments, backlinks and CSRF token have to be filled in on p before this is called
+func viewETag(r *http.Request, p *Page, exists func(title string) bool) string {
+	h := sha256.New()
+	field := func(s string) {
+		io.WriteString(h, s)
+		h.Write([]byte{0})
+	}
+	field(startTag)
+	h.Write(p.Body)
+	h.Write([]byte{0})
+	for _, title := range linkedTitles(p) {
+		field(title + "=" + strconv.FormatBool(exists(title)))
+	}
+	field(strings.Join(p.Attachments, "/"))
+	field(strings.Join(p.Backlinks, "/"))
+	field(theme(r))
+	field(lang(r))
+	field(p.CSRFToken)
+	field(strconv.FormatBool(*readOnly))
+	field(r.URL.RawQuery)
+	// Templates are parsed again for every request in dev mode, so nothing can be assumed to stay the same
+	if *dev {
+		field(time.Now().String())
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// viewModTime returns the Last-Modified time of a page's HTML view, the newest of everything it's rendered from that has one:
+// the page itself, the pages it links to and that link to it, its attachments, and the server starting with maybe new templates.
+// A linked page being deleted or a link to the page being taken away doesn't move it on, but the ETag does,
+// and clients that have both send If-None-Match, which notModified goes by first.
+// In -dev mode the templates can change at any time, so there's no Last-Modified at all.
+// The backlinks have to be filled in on p before this is called
+func (s *wikiServer) viewModTime(ctx context.Context, p *Page) time.Time {
+	if *dev {
+		return time.Time{}
+	}
+	newest := started
+	newer := func(t time.Time) {
+		if t.After(newest) {
+			newest = t
+		}
+	}
+	newer(p.ModTime)
+	for _, title := range append(linkedTitles(p), p.Backlinks...) {
+		if other, err := s.loadPage(ctx, title); err == nil {
+			newer(other.ModTime)
+		}
+	}
+	// The directory changes when an attachment is added or removed, and each file when it's replaced
+	dir := attachmentDir(p.Title)
+	if info, err := os.Stat(dir); err == nil {
+		newer(info.ModTime())
+	}
+	for _, name := range p.Attachments {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			newer(info.ModTime())
+		}
+	}
+	return newest
+}
+
+// addVary adds field to the Vary header, unless it's already there
+// Everything on the way to the client adds what the response depends on, and some of them are the same
+func addVary(h http.Header, field string) {
+	for _, v := range h.Values("Vary") {
+		for f := range strings.SplitSeq(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(f), field) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", field)
+}
+
+// etagWithSuffix tells apart different representations of the same resource by adding suffix to its ETag
+// Weak ETags only promise the same meaning rather than the same bytes, so they're shared by all of them and left alone
+func etagWithSuffix(etag, suffix string) string {
+	if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) || len(etag) < 2 {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + suffix + `"`
+}
+
+// contentHashHeader carries contentHash on the view page, for clients that want to check what they got
+const contentHashHeader = "X-Content-SHA256"
+
+// contentHash returns the full SHA-256 of a page's body in hex
+// Unlike the ETag, which is only meant to tell versions apart, this is the whole hash of exactly the
+// stored body, so a client with the raw page can check it
+func contentHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// gzipETagSuffix is added to a strong ETag by gzipMiddleware when it compresses the response
+// The gzipped bytes aren't the same as the plain ones, so they can't have the same strong ETag
+const gzipETagSuffix = "-gzip"
+
+// gzipETag returns the ETag for the gzipped version of the response tagged etag
+func gzipETag(etag string) string {
+	return etagWithSuffix(etag, gzipETagSuffix)
+}
+
+// stripGzipETags takes gzipETagSuffix off every tag in an If-None-Match header that has it
+// The handler only knows the ETag of its uncompressed response, so this is how a client's gzipped copy is matched
+func stripGzipETags(header string) string {
+	tags := strings.Split(header, ",")
+	for i, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if t, ok := strings.CutSuffix(tag, gzipETagSuffix+`"`); ok {
+			tag = t + `"`
+		}
+		tags[i] = tag
+	}
+	return strings.Join(tags, ", ")
+}
+
+// etagMatches reports whether etag is one of the tags in an If-None-Match header
+// If-None-Match uses the weak comparison, so a W/ prefix on either side is ignored.
+// Tags gzipETag made never match here, gzipMiddleware turns them back into the plain ones when it's compressing
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag and Last-Modified headers for a response and
+// reports whether the client's cached copy is still current, in which case a 304 should be sent.
+// If-None-Match takes priority over If-Modified-Since as required by RFC 9110
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time) bool {
+	h := w.Header()
+	h.Set("ETag", etag)
+	// Browsers may keep the page but have to check it is still current before using it
+	h.Set("Cache-Control", "no-cache")
+	if !modTime.IsZero() {
+		h.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modTime.IsZero() {
+		t, err := http.ParseTime(ims)
+		// Last-Modified only has second precision, so compare at that precision
+		return err == nil && !modTime.Truncate(time.Second).After(t)
+	}
+	return false
+}
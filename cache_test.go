@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// The view's ETag has to change with everything the page is rendered from, not just its own body
+func TestViewNotModified(t *testing.T) {
+	s, h := newTestWiki(t)
+	addPage(t, s, "Home", "see [Other]")
+	get := func(etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/view/Home", nil)
+		r.AddCookie(testSession)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		return serve(h, r)
+	}
+
+	etag := get("").Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("view has no ETag")
+	}
+	if w := get(etag); w.Code != http.StatusNotModified {
+		t.Fatalf("unchanged page with its ETag got %d, want 304", w.Code)
+	}
+
+	changes := []struct {
+		name   string
+		change func()
+	}{
+		{"linked page created", func() { addPage(t, s, "Other", "here now") }},
+		{"backlink added", func() { addPage(t, s, "Elsewhere", "back to [Home]") }},
+		{"attachment added", func() {
+			dir := attachmentDir("Home")
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"body changed", func() { addPage(t, s, "Home", "see [Other] again") }},
+	}
+	for _, c := range changes {
+		c.change()
+		w := get(etag)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: old ETag got %d, want 200", c.name, w.Code)
+		}
+		etag = w.Header().Get("ETag")
+		if w := get(etag); w.Code != http.StatusNotModified {
+			t.Errorf("%s: new ETag got %d, want 304", c.name, w.Code)
+		}
+	}
+}
+
+// The view's Last-Modified is the newest of the pages it's rendered from, and a 304 varies by the same as the 200
+func TestViewLastModified(t *testing.T) {
+	s, h := newTestWiki(t)
+	addPage(t, s, "Home", "see [Other]")
+	get := func(ims string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/view/Home", nil)
+		r.AddCookie(testSession)
+		if ims != "" {
+			r.Header.Set("If-Modified-Since", ims)
+		}
+		return serve(h, r)
+	}
+
+	w := get("")
+	lastModified := w.Header().Get("Last-Modified")
+	if lastModified == "" {
+		t.Fatal("view has no Last-Modified")
+	}
+	notModified := get(lastModified)
+	if notModified.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since of its Last-Modified got %d, want 304", notModified.Code)
+	}
+	if got, want := notModified.Header().Values("Vary"), w.Header().Values("Vary"); !slices.Equal(got, want) {
+		t.Errorf("304 has Vary %q, the 200 %q", got, want)
+	}
+	early := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	if w := get(early); w.Code != http.StatusOK {
+		t.Errorf("If-Modified-Since an hour ago got %d, want 200", w.Code)
+	}
+
+	ctx := context.Background()
+	home, err := s.loadPage(ctx, "Home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ name, title, body string }{
+		{"linked page", "Other", "here now"},
+		{"backlink", "Elsewhere", "back to [Home]"},
+	} {
+		addPage(t, s, c.title, c.body)
+		other, err := s.loadPage(ctx, c.title)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if home.Backlinks, err = s.backlinks(ctx, "Home"); err != nil {
+			t.Fatal(err)
+		}
+		if got := s.viewModTime(ctx, home); !got.Equal(other.ModTime) {
+			t.Errorf("%s saved at %v, view modified at %v", c.name, other.ModTime, got)
+		}
+	}
+}
+
+// The HTML, Markdown and plain text views of a page are different responses, so none of them can share an ETag
+func TestViewFormatETags(t *testing.T) {
+	s, h := newTestWiki(t)
+	addPage(t, s, "Home", "# Home")
+	etags := make(map[string]string)
+	for _, accept := range []string{"text/html", "text/markdown", "text/plain"} {
+		r := httptest.NewRequest("GET", "/view/Home", nil)
+		r.AddCookie(testSession)
+		r.Header.Set("Accept", accept)
+		etag := serve(h, r).Header().Get("ETag")
+		if other, ok := etags[etag]; ok {
+			t.Errorf("%s and %s have the same ETag %s", accept, other, etag)
+		}
+		etags[etag] = accept
+
+		r = httptest.NewRequest("GET", "/view/Home", nil)
+		r.AddCookie(testSession)
+		r.Header.Set("Accept", accept)
+		r.Header.Set("If-None-Match", etag)
+		if w := serve(h, r); w.Code != http.StatusNotModified {
+			t.Errorf("%s with its own ETag got %d, want 304", accept, w.Code)
+		}
+	}
+}
+
+// X-Content-SHA256 is the SHA-256 of the body exactly as stored, on the HTML page and the raw source alike
+func TestContentHash(t *testing.T) {
+	s, h := newTestWiki(t)
+	addPage(t, s, "Hashed", "hello world")
+	const want = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+
+	for _, path := range []string{"/view/Hashed", "/view/Hashed?raw=1"} {
+		w := serve(h, httptest.NewRequest("GET", path, nil))
+		if got := w.Header().Get(contentHashHeader); w.Code != http.StatusOK || got != want {
+			t.Errorf("GET %s got %d with %s %q, want %q", path, w.Code, contentHashHeader, got, want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Concurrency flags, at most maxConcurrent requests are handled at once
+// A request over the limit waits up to queueTimeout for one to finish, or is turned away straight away if that's 0
+var (
+	maxConcurrent = flag.Int("maxconcurrent", 0, "maximum number of requests handled at once, 0 for no limit")
+	queueTimeout  = flag.Duration("queuetimeout", 0, "how long a request over -maxconcurrent waits for a slot before a 503, 0 to not wait")
+)
+
+// concurrencyMiddleware limits how many requests h is handling at once to -maxconcurrent
+// The buffered channel is the semaphore, a request holds a slot by having sent to it.
+// The slot is given back in a defer, so a handler that panics doesn't keep it while recoverMiddleware deals with it.
+// It goes inside timeoutMiddleware, so a request that has timed out keeps its slot until the handler has actually stopped,
+// not just until the client has been sent its 503.
+// Event streams don't take a slot, as each one would keep it for as long as its page was open
+func concurrencyMiddleware(h http.Handler) http.Handler {
+	if *maxConcurrent <= 0 {
+		return h
+	}
+	slots := make(chan struct{}, *maxConcurrent)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/events/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if !acquireSlot(r, slots) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "server is busy, try again shortly", http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { <-slots }()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// acquireSlot takes a slot in slots, waiting up to -queuetimeout for one to come free
+// It gives up early if the client goes away while it's waiting
+func acquireSlot(r *http.Request, slots chan struct{}) bool {
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+	if *queueTimeout <= 0 {
+		return false
+	}
+	t := time.NewTimer(*queueTimeout)
+	defer t.Stop()
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-t.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+)
+
+// csrfCookie is the name of the cookie the session's CSRF token is kept in
+const csrfCookie = "csrf_token"
+
+// csrfField is the name of the hidden form field forms send the token back in
+const csrfField = "csrf_token"
+
+// csrfToken returns the CSRF token for the client's session, starting a new session if it doesn't have one
+// A form has to include the token for its POST to be accepted. Another site can make the browser send
+// the cookie, but it can't read it, so it can't put the matching token in the form
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && len(c.Value) == 64 {
+		return c.Value
+	}
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     pathTo("/"),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token
+}
+
+// validCSRF reports whether a POST carries a token matching its session cookie
+// The comparison is constant time so the token can't be guessed a byte at a time
+func validCSRF(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.PostFormValue(csrfField))) == 1
+}
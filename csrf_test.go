@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// A POST without a token matching its session cookie is refused before it changes anything
+func TestCSRF(t *testing.T) {
+	s, h := newTestWiki(t)
+	addPage(t, s, "Home", "original")
+	other := strings.Repeat("f", 64)
+	tests := []struct {
+		name          string
+		cookie, field string
+	}{
+		{"no token at all", "", ""},
+		{"cookie without field", testSession.Value, ""},
+		{"field without cookie", "", testSession.Value},
+		{"mismatched token", testSession.Value, other},
+	}
+	for _, path := range []string{"/save/Home", "/delete/Home", "/rename/Home"} {
+		for _, tt := range tests {
+			form := url.Values{"body": {"changed"}, "newtitle": {"Moved"}}
+			if tt.field != "" {
+				form.Set(csrfField, tt.field)
+			}
+			r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: csrfCookie, Value: tt.cookie})
+			}
+			if w := serve(h, r); w.Code != http.StatusForbidden {
+				t.Errorf("POST %s with %s got %d, want 403", path, tt.name, w.Code)
+			}
+		}
+	}
+	if p, err := s.loadPage(t.Context(), "Home"); err != nil || string(p.Body) != "original" {
+		t.Errorf("after the refused POSTs Home is %v, %v", p, err)
+	}
+
+	if w := savePageForm(h, "Home", "changed"); w.Code != http.StatusFound {
+		t.Errorf("save with a valid token got %d, want 302", w.Code)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// The kinds of line a diff is made up of
+const (
+	diffSame    = "same"
+	diffAdded   = "added"
+	diffRemoved = "removed"
+)
+
+// A DiffLine is a single line of a diff along with whether it was kept, added or removed
+// Op doubles as the CSS class the line is given in the diff template
+type DiffLine struct {
+	Op   string
+	Text string
+}
+
+// maxDiffCells is the most entries the table diffLines works from can have
+// It takes a slot for every pair of lines, so two big revisions that have little in common would otherwise
+// need gigabytes. At this size it's a few megabytes, enough for pages with a thousand lines changed at once
+const maxDiffCells = 1 << 20
+
+// diffLines computes a line based diff that turns a into b
+// Lines the two start and end with are kept as they are, then it finds the longest common subsequence
+// of what's left with the standard dynamic programming table, and walks the table to emit the kept,
+// removed and added lines in order. If what's left would make the table bigger than maxDiffCells,
+// all of a's lines are shown removed and then all of b's added instead, and exact is false
+func diffLines(a, b []string) (lines []DiffLine, exact bool) {
+	var head, tail []DiffLine
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		head = append(head, DiffLine{diffSame, a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		tail = append(tail, DiffLine{diffSame, a[len(a)-1]})
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+	slices.Reverse(tail)
+
+	out := head
+	exact = (len(a)+1)*(len(b)+1) <= maxDiffCells
+	if exact {
+		out = append(out, diffLCS(a, b)...)
+	} else {
+		for _, line := range a {
+			out = append(out, DiffLine{diffRemoved, line})
+		}
+		for _, line := range b {
+			out = append(out, DiffLine{diffAdded, line})
+		}
+	}
+	return append(out, tail...), exact
+}
+
+// diffLCS is diffLines without the shortcuts, building the whole table for a and b
+func diffLCS(a, b []string) []DiffLine {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out []DiffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, DiffLine{diffSame, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, DiffLine{diffRemoved, a[i]})
+			i++
+		default:
+			out = append(out, DiffLine{diffAdded, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, DiffLine{diffRemoved, a[i]})
+	}
+	for ; j < len(b); j++ {
+		out = append(out, DiffLine{diffAdded, b[j]})
+	}
+	return out
+}
+
+// splitLines breaks a page body into lines, ignoring a trailing newline so it doesn't show up as an empty line
+func splitLines(body []byte) []string {
+	s := strings.TrimSuffix(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// diffHandler shows what changed between the revisions given by the a and b query parameters
+// A missing revision is a 404 that says which of the two couldn't be found
+func (s *wikiServer) diffHandler(w http.ResponseWriter, r *http.Request, title string) {
+	q := r.URL.Query()
+	a, b := q.Get("a"), q.Get("b")
+	pa, err := s.loadRevision(r.Context(), title, a)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, fmt.Sprintf("revision a (%q) of %s not found", a, title), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	pb, err := s.loadRevision(r.Context(), title, b)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, fmt.Sprintf("revision b (%q) of %s not found", b, title), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	ta, _ := parseTimestamp(a)
+	tb, _ := parseTimestamp(b)
+	lines, exact := diffLines(splitLines(pa.Body), splitLines(pb.Body))
+	renderTemplate(w, r, "diff", struct {
+		Title string
+		A, B  Revision
+		Lines []DiffLine
+		Exact bool
+	}{
+		Title: title,
+		A:     Revision{Title: title, Timestamp: a, Time: ta},
+		B:     Revision{Title: title, Timestamp: b, Time: tb},
+		Lines: lines,
+		Exact: exact,
+	})
+}
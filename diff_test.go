@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	got, exact := diffLines([]string{"a", "b", "c", "d"}, []string{"a", "c", "e", "d"})
+	want := []DiffLine{{diffSame, "a"}, {diffRemoved, "b"}, {diffSame, "c"}, {diffAdded, "e"}, {diffSame, "d"}}
+	if !exact || !slices.Equal(got, want) {
+		t.Errorf("diffLines = %v, %v, want %v, true", got, exact, want)
+	}
+}
+
+// Revisions too different to build the table for are shown as everything removed then everything added,
+// keeping the lines they start and end with
+func TestDiffLinesTooBig(t *testing.T) {
+	var a, b []string
+	a = append(a, "first")
+	b = append(b, "first")
+	for i := range 2000 {
+		a = append(a, fmt.Sprint("old ", i))
+		b = append(b, fmt.Sprint("new ", i))
+	}
+	a = append(a, "last")
+	b = append(b, "last")
+
+	got, exact := diffLines(a, b)
+	if exact {
+		t.Fatal("diffLines claims an exact diff of revisions too big to compare")
+	}
+	if len(got) != 4002 || got[0] != (DiffLine{diffSame, "first"}) || got[len(got)-1] != (DiffLine{diffSame, "last"}) {
+		t.Fatalf("diffLines returned %d lines from %v to %v", len(got), got[0], got[len(got)-1])
+	}
+	for i, line := range got[1 : len(got)-1] {
+		op := diffAdded
+		if i < 2000 {
+			op = diffRemoved
+		}
+		if line.Op != op {
+			t.Fatalf("line %d is %v, want the old lines removed and then the new ones added", i+1, line)
+		}
+	}
+}
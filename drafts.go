@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// draftPath returns the file the autosaved draft of a page is kept in
+// Like attachments, drafts are always kept on disk under -datadir, whichever store the pages are in
+func draftPath(title string) string {
+	return filepath.Join(*dataDir, "drafts", filepath.FromSlash(title)+".txt")
+}
+
+// saveDraft stores the in-progress body of a page, replacing any draft already there
+// A draft isn't a revision, so it never shows up in the page's history
+func saveDraft(title string, body []byte) error {
+	filename := draftPath(title)
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, body, 0600)
+}
+
+// loadDraft returns the draft of a page and when it was saved
+// A page without a draft gives an error satisfying errors.Is(err, os.ErrNotExist)
+func loadDraft(title string) ([]byte, time.Time, error) {
+	filename := draftPath(title)
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	body, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return body, info.ModTime(), nil
+}
+
+// clearDraft removes the draft of a page, if it has one
+func clearDraft(title string) error {
+	err := os.Remove(draftPath(title))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// draftHandler stores the body sent by the edit form's autosave on POST /draft/<title>
+// It needs the same CSRF token as saving, and answers with a 204 as there's nothing to show
+func draftHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, *maxSize)
+	if err := r.ParseForm(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "draft is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validCSRF(r) {
+		http.Error(w, "invalid or missing CSRF token", http.StatusForbidden)
+		return
+	}
+	if err := saveDraft(title, []byte(r.FormValue("body"))); err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addDraft tells the edit form about a draft of the page newer than what was last saved
+// With ?draft=1 the draft is put into the form in place of the saved body
+func addDraft(r *http.Request, p *Page) {
+	body, saved, err := loadDraft(p.Title)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Error("loading draft", "title", p.Title, "err", err)
+		}
+		return
+	}
+	// A draft older than the page was left behind by an edit that someone else's save overtook
+	if !saved.After(p.ModTime) {
+		return
+	}
+	p.DraftTime = saved
+	if r.URL.Query().Get("draft") == "1" {
+		p.Body = body
+		p.DraftTime = time.Time{}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// writeError sends the client a plain message for the status code, and logs the error that caused it
+// Errors from the store and the filesystem can carry paths and other details of the server,
+// which are useful in the log but shouldn't be shown to whoever made the request
+func writeError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	logRequestError(r, err)
+	http.Error(w, http.StatusText(status), status)
+}
+
+// logRequestError logs the error a request failed with, at error along with which request it was
+func logRequestError(r *http.Request, err error) {
+	slog.Error("handling request", "request_id", requestIDFromContext(r.Context()), "method", r.Method, "path", r.URL.Path, "err", err)
+}
+
+// writeJSONInternalError is writeError for the JSON API, always with a 500
+func writeJSONInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	logRequestError(r, err)
+	writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
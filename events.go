@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// eventsPath matches /events/<title>, the stream of changes to a page the view page listens on
+var eventsPath = regexp.MustCompile("^/events/(" + titlePattern + ")$")
+
+// eventsKeepAlive is how often an idle event stream gets a comment, so proxies don't time it out
+const eventsKeepAlive = 30 * time.Second
+
+// pageEvents lets handlers wait for pages to be saved
+// Each subscriber has its own channel, which gets a value when the page is saved. The channels hold
+// one value and publish never blocks, so a subscriber that's behind just sees several saves as one.
+// done is closed when the server shuts down, so the streams end rather than holding up the shutdown
+type pageEvents struct {
+	mu   sync.Mutex
+	subs map[string]map[chan struct{}]bool
+	done chan struct{}
+	once sync.Once
+}
+
+// events is where saves are published for /events/<title>
+var events = &pageEvents{subs: make(map[string]map[chan struct{}]bool), done: make(chan struct{})}
+
+// subscribe returns a channel that gets a value every time the page with the given title is saved
+// The function returned unsubscribes and closes the channel, and has to be called once the channel isn't wanted
+func (e *pageEvents) subscribe(title string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	e.mu.Lock()
+	if e.subs[title] == nil {
+		e.subs[title] = make(map[chan struct{}]bool)
+	}
+	e.subs[title][ch] = true
+	e.mu.Unlock()
+	return ch, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		delete(e.subs[title], ch)
+		if len(e.subs[title]) == 0 {
+			delete(e.subs, title)
+		}
+		close(ch)
+	}
+}
+
+// publish tells everyone subscribed to the page with the given title that it has been saved
+func (e *pageEvents) publish(title string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch := range e.subs[title] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// close ends every event stream, it's registered to run when the server starts shutting down
+func (e *pageEvents) close() {
+	e.once.Do(func() { close(e.done) })
+}
+
+// eventsHandler streams server-sent events on /events/<title>, a saved event every time the page is saved
+// The view page's live.js reloads the page when it gets one. The stream stays open until the client goes away,
+// so the write timeout is lifted for it, and it's left out of -handlertimeout and -maxconcurrent
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	m := eventsPath.FindStringSubmatch(r.URL.Path)
+	if m == nil || validateTitle(m[1]) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	title := m[1]
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	saved, unsubscribe := events.subscribe(title)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// The comment gets the headers out straight away, so the browser knows the stream is open
+	fmt.Fprint(w, ": listening for saves\n\n")
+	if err := rc.Flush(); err != nil {
+		slog.Error("starting event stream", "title", title, "err", err)
+		return
+	}
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-saved:
+			fmt.Fprintf(w, "event: saved\ndata: %s\n\n", title)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep alive\n\n")
+		case <-r.Context().Done():
+			return
+		case <-events.done:
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			// The client has gone, which the context will say soon enough anyway
+			return
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// exportHandler sends every page in the wiki as a zip archive on /export
+// Each page is a <title>.txt entry, with nested titles in directories just like the file store keeps them.
+// Pages are read through the store rather than straight off the disk, so this works whatever -store is.
+// The archive is streamed as it's built, so if something goes wrong partway through all we can do is log it
+func (s *wikiServer) exportHandler(w http.ResponseWriter, r *http.Request) {
+	titles, err := s.listPages(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="wiki.zip"`)
+	zw := zip.NewWriter(w)
+	for _, title := range titles {
+		p, err := s.loadPage(r.Context(), title)
+		if err != nil {
+			// Deleted since it was listed
+			continue
+		}
+		// Titles are checked by validateTitle, so this is always a clean relative path with forward slashes
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     title + ".txt",
+			Method:   zip.Deflate,
+			Modified: p.ModTime,
+		})
+		if err != nil {
+			slog.Error("exporting page", "title", title, "err", err)
+			return
+		}
+		if _, err := f.Write(p.Body); err != nil {
+			slog.Error("exporting page", "title", title, "err", err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		slog.Error("finishing export", "err", err)
+	}
+}
+
+// exportedPage is one page in the JSON sent by /api/export
+type exportedPage struct {
+	Title    string    `json:"title"`
+	Body     string    `json:"body"`
+	Modified time.Time `json:"modified"`
+}
+
+// apiExportHandler handles GET /api/export, every page as a JSON array for moving the wiki somewhere else
+// ?since=<unix time> only sends the pages saved after then, so a copy can be kept up to date without fetching it all.
+// Like /export the pages are loaded and written one at a time, so an error partway through can only be logged
+func (s *wikiServer) apiExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		secs, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "since must be a unix time in seconds")
+			return
+		}
+		since = time.Unix(secs, 0)
+	}
+	titles, err := s.listPages(r.Context())
+	if err != nil {
+		writeJSONInternalError(w, r, err)
+		return
+	}
+	slices.Sort(titles)
+
+	w.Header().Set("Content-Type", "application/json")
+	// The array is written by hand around the pages so only one of them is in memory at a time
+	w.Write([]byte("["))
+	enc := json.NewEncoder(w)
+	first := true
+	for _, title := range titles {
+		p, err := s.loadPage(r.Context(), title)
+		if err != nil {
+			// Deleted since it was listed
+			continue
+		}
+		if !p.ModTime.After(since) {
+			continue
+		}
+		if !first {
+			w.Write([]byte(","))
+		}
+		first = false
+		if err := enc.Encode(exportedPage{p.Title, string(p.Body), p.ModTime.UTC()}); err != nil {
+			slog.Error("exporting page", "title", title, "err", err)
+			return
+		}
+	}
+	w.Write([]byte("]\n"))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// faviconPath is an icon file to serve instead of the built in one, or "none" to serve no icon at all
+var faviconPath = flag.String("favicon", "", `icon file served on /favicon.ico instead of the built in one, "none" for no icon`)
+
+// favicon is the icon being served, set up in main by loadFavicon. It's nil when there isn't one
+var favicon []byte
+
+// faviconMaxAge is how long browsers can keep the icon without asking for it again
+const faviconMaxAge = 7 * 24 * time.Hour
+
+// loadFavicon reads the icon picked with -favicon
+// It runs before the server starts so a missing file is reported straight away
+func loadFavicon() error {
+	switch *faviconPath {
+	case "":
+		// The built in icon, embedded along with the rest of static/
+		b, err := embeddedStatic.ReadFile("static/favicon.ico")
+		if err != nil {
+			return fmt.Errorf("reading favicon: %w", err)
+		}
+		favicon = b
+	case "none":
+		favicon = nil
+	default:
+		b, err := os.ReadFile(*faviconPath)
+		if err != nil {
+			return fmt.Errorf("reading favicon: %w", err)
+		}
+		favicon = b
+	}
+	return nil
+}
+
+// faviconHandler serves the icon browsers ask for on /favicon.ico
+// With no icon it's a 204, so browsers stop asking without the request going to the 404 page
+func faviconHandler(w http.ResponseWriter, r *http.Request) {
+	if favicon == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "image/x-icon")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(faviconMaxAge.Seconds())))
+	// ServeContent answers If-None-Match from the ETag, the icon has no modification time to go by
+	w.Header().Set("ETag", pageETag(favicon))
+	http.ServeContent(w, r, "favicon.ico", time.Time{}, bytes.NewReader(favicon))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// frontMatterDelim is the line that opens and closes a front matter block
+const frontMatterDelim = "---"
+
+// frontMatter is the metadata a page can carry in a YAML block at the top of its body, e.g.
+//
+//	---
+//	author: Andrew
+//	tags: [go, wiki]
+//	updated: 2024-05-01
+//	format: html
+//	---
+type frontMatter struct {
+	Author  string    `yaml:"author"`
+	Tags    []string  `yaml:"tags"`
+	Updated time.Time `yaml:"updated"`
+	Format  string    `yaml:"format"`
+}
+
+// The formats a page body can be written in, set with format: in the front matter
+// Pages are Markdown unless they say otherwise, and an HTML page is shown as it is, apart from the sanitizer
+const (
+	formatMarkdown = "markdown"
+	formatHTML     = "html"
+)
+
+// validFormat reports whether format is one a page can be written in
+func validFormat(format string) bool {
+	return format == formatMarkdown || format == formatHTML
+}
+
+// formatLine matches the format: line in a front matter block
+var formatLine = regexp.MustCompile(`(?m)^format:.*(?:\r?\n|$)`)
+
+// setFormat returns body with the format: line in its front matter changed to format
+// Markdown being the default, it's left out for Markdown pages, along with the whole block if that was all it had.
+// The rest of the front matter is kept as it was written rather than going through YAML and back
+func setFormat(body []byte, format string) []byte {
+	fm, content := splitFrontMatter(body)
+	fm = formatLine.ReplaceAll(fm, nil)
+	if format != formatMarkdown {
+		fm = append([]byte("format: "+format+"\n"), fm...)
+	}
+	if len(bytes.TrimSpace(fm)) == 0 {
+		return content
+	}
+	var out bytes.Buffer
+	out.WriteString(frontMatterDelim + "\n")
+	out.Write(fm)
+	out.WriteString(frontMatterDelim + "\n")
+	out.Write(content)
+	return out.Bytes()
+}
+
+// splitFrontMatter separates the front matter block at the top of a body from the content after it
+// If the body doesn't start with a complete block, fm is nil and content is the whole body
+func splitFrontMatter(body []byte) (fm, content []byte) {
+	rest, ok := cutLine(body, frontMatterDelim)
+	if !ok {
+		return nil, body
+	}
+	for i := 0; i < len(rest); {
+		line := rest[i:]
+		if after, ok := cutLine(line, frontMatterDelim); ok {
+			return rest[:i], after
+		}
+		nl := bytes.IndexByte(line, '\n')
+		if nl < 0 {
+			break
+		}
+		i += nl + 1
+	}
+	return nil, body
+}
+
+// cutLine reports whether b starts with a line that is exactly s, and returns what comes after that line
+func cutLine(b []byte, s string) ([]byte, bool) {
+	rest, ok := bytes.CutPrefix(b, []byte(s))
+	if !ok {
+		return b, false
+	}
+	if len(rest) == 0 {
+		return rest, true
+	}
+	if after, ok := bytes.CutPrefix(rest, []byte("\r\n")); ok {
+		return after, true
+	}
+	if after, ok := bytes.CutPrefix(rest, []byte("\n")); ok {
+		return after, true
+	}
+	return b, false
+}
+
+// parseFrontMatter fills in the page's metadata from the front matter at the top of its body
+// A page without front matter, or with a block that isn't valid YAML, just ends up with no metadata.
+// In the second case the block is left in the content so it shows up on the page and can be fixed
+func (p *Page) parseFrontMatter() {
+	fm, content := splitFrontMatter(p.Body)
+	var meta frontMatter
+	if fm == nil || yaml.Unmarshal(fm, &meta) != nil {
+		p.content = p.Body
+		p.Format = formatMarkdown
+		return
+	}
+	p.Author, p.Tags, p.Updated = meta.Author, meta.Tags, meta.Updated
+	p.Format = formatMarkdown
+	if validFormat(meta.Format) {
+		p.Format = meta.Format
+	}
+	p.content = content
+}
+
+// Content returns the part of the body that is shown when the page is viewed, everything after the front matter
+func (p *Page) Content() []byte {
+	if p.content == nil {
+		p.parseFrontMatter()
+	}
+	return p.content
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"html/template"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// displayTime is how times are shown to readers, in the server's local time zone
+const displayTime = "Mon, 2 Jan 2006 15:04 MST"
+
+// templateFuncs returns the helpers every template can call, on top of the built in ones
+// t looks up a UI string in lang, see translate, and base is -basepath for starting links with
+func templateFuncs(lang string) template.FuncMap {
+	return template.FuncMap{
+		"t":          func(key string, args ...any) string { return translate(lang, key, args...) },
+		"base":       func() string { return *basePath },
+		"formatTime": formatTime,
+		"truncate":   truncate,
+		"urlize":     urlize,
+	}
+}
+
+// formatTime shows t in the server's local time for a reader, e.g. Mon, 2 Jan 2006 15:04 MST
+func formatTime(t time.Time) string {
+	return t.Local().Format(displayTime)
+}
+
+// truncate cuts s down to at most n characters, ending it with … if anything was cut off
+// n comes first so it can be used at the end of a pipeline, like {{.Snippet | truncate 80}}
+func truncate(n int, s string) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
+
+// bareURL matches http and https URLs written out in plain text
+// Trailing punctuation is left out, as it's much more likely to end the sentence than the URL
+var bareURL = regexp.MustCompile(`https?://[^\s<>"]*[^\s<>".,;:!?')\]]`)
+
+// urlize escapes s and turns any URLs in it into links, for text from front matter that isn't rendered as Markdown
+func urlize(s string) template.HTML {
+	var b strings.Builder
+	last := 0
+	for _, loc := range bareURL.FindAllStringIndex(s, -1) {
+		b.WriteString(template.HTMLEscapeString(s[last:loc[0]]))
+		u := template.HTMLEscapeString(s[loc[0]:loc[1]])
+		b.WriteString(`<a href="` + u + `" rel="nofollow">` + u + `</a>`)
+		last = loc[1]
+	}
+	b.WriteString(template.HTMLEscapeString(s[last:]))
+	return template.HTML(b.String())
+}
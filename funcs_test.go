@@ -0,0 +1,37 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The helpers work from inside a template the way the page templates use them
+func TestTemplateFuncs(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("EST", -5*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	tmpl := template.Must(template.New("funcs").Funcs(templateFuncs("en")).Parse(
+		`{{formatTime .When}}|{{.Snippet | truncate 12}}|{{.Short | truncate 12}}|{{urlize .Note}}`))
+	data := struct {
+		When           time.Time
+		Snippet, Short string
+		Note           string
+	}{
+		When:    time.Date(2024, time.March, 5, 14, 30, 0, 0, time.UTC),
+		Snippet: "a rather long snippet of the page",
+		Short:   "short enough",
+		Note:    "see https://example.com/a?b=1&c=2, or <b>this</b>",
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		t.Fatal(err)
+	}
+	want := "Tue, 5 Mar 2024 09:30 EST|a rather lo…|short enough|" +
+		`see <a href="https://example.com/a?b=1&amp;c=2" rel="nofollow">https://example.com/a?b=1&amp;c=2</a>, or &lt;b&gt;this&lt;/b&gt;`
+	if b.String() != want {
+		t.Errorf("template gave\n%s\nwant\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// A Checker is a Store that can tell whether it is able to serve requests
+// readyzHandler uses it when the configured store is one
+type Checker interface {
+	Check() error
+}
+
+// Check makes sure the data directory is there and is a directory
+func (s *FileStore) Check() error {
+	info, err := os.Stat(s.Dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", s.Dir)
+	}
+	if info.Mode().Perm()&0200 == 0 {
+		return fmt.Errorf("%s is not writable", s.Dir)
+	}
+	return nil
+}
+
+// Check makes sure the database can still be reached
+func (s *SQLiteStore) Check() error {
+	return s.db.Ping()
+}
+
+// healthzHandler is the liveness probe, if the server can answer at all it's alive
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, "ok")
+}
+
+// readyzHandler is the readiness probe, it is a 503 when the store can't be used
+func (s *wikiServer) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if c, ok := s.store.(Checker); ok {
+		if err := c.Check(); err != nil {
+			// The error names the data directory or database, which is for the logs rather than whoever is asking
+			logRequestError(r, err)
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, "ok")
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// /readyz is a 503 once the data directory has gone, while /healthz still says the server is alive
+func TestReadyz(t *testing.T) {
+	setFlag(t, dataDir, t.TempDir())
+	dir := filepath.Join(t.TempDir(), "data")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	h := newWikiServer(NewFileStore(dir)).routes()
+
+	if w := serve(h, httptest.NewRequest("GET", "/readyz", nil)); w.Code != http.StatusOK {
+		t.Errorf("/readyz with the data directory there got %d, want 200", w.Code)
+	}
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	w := serve(h, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz without the data directory got %d, want 503", w.Code)
+	}
+	if strings.Contains(w.Body.String(), dir) {
+		t.Errorf("/readyz told the client where the data directory is: %s", w.Body)
+	}
+	if w := serve(h, httptest.NewRequest("GET", "/healthz", nil)); w.Code != http.StatusOK {
+		t.Errorf("/healthz got %d, want 200", w.Code)
+	}
+}
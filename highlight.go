@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+)
+
+// highlightStyle and highlightDarkStyle are the chroma styles code is coloured with in the light and dark themes
+const (
+	highlightStyle     = "github"
+	highlightDarkStyle = "monokai"
+)
+
+// highlighter colours fenced code blocks that name their language, like ```go
+// The colours come from classes rather than inline styles so the dark theme can change them.
+// Blocks without a language, or with one chroma doesn't know, are left as a plain <pre>
+var highlighter = highlighting.NewHighlighting(
+	highlighting.WithStyle(highlightStyle),
+	highlighting.WithFormatOptions(chromahtml.WithClasses(true)),
+)
+
+// highlightCSS builds the stylesheet for the classes highlighter puts on code, the first time it's asked for
+// The dark style's rules are put under html.dark so they only apply in the dark theme
+var highlightCSS = sync.OnceValue(func() []byte {
+	formatter := chromahtml.New(chromahtml.WithClasses(true))
+	var light, dark bytes.Buffer
+	formatter.WriteCSS(&light, styles.Get(highlightStyle))
+	formatter.WriteCSS(&dark, styles.Get(highlightDarkStyle))
+	// Every rule chroma writes is a comment naming it followed by the selector
+	light.WriteString(strings.ReplaceAll(dark.String(), "*/ .", "*/ html.dark ."))
+	return light.Bytes()
+})
+
+// highlightCSSHandler serves the syntax highlighting stylesheet on /highlight.css
+func highlightCSSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Write(highlightCSS())
+}
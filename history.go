@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// A Revision is a snapshot of a page taken every time it is saved
+// Timestamp is the unix time in nanoseconds the snapshot was taken, which is also its filename
+// Previous is the timestamp of the revision before it, if there is one, so it can be diffed against
+type Revision struct {
+	Title     string
+	Timestamp string
+	Time      time.Time
+	Previous  string
+}
+
+// parseTimestamp checks that ts is a revision timestamp and converts it into a time
+// As ts ends up in a filename, anything that isn't a plain number is rejected.
+// There can't be a revision with a name like that, so the error counts as os.ErrNotExist
+func parseTimestamp(ts string) (time.Time, error) {
+	n, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || n < 0 {
+		return time.Time{}, fmt.Errorf("invalid revision %q: %w", ts, os.ErrNotExist)
+	}
+	return time.Unix(0, n), nil
+}
+
+// loadRevision reads a single snapshot of a page from its history
+// Stores that don't keep history have no revisions to load
+func (s *wikiServer) loadRevision(ctx context.Context, title, ts string) (*Page, error) {
+	rs, ok := s.store.(RevisionStore)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return rs.LoadRevision(ctx, title, ts)
+}
+
+// listRevisions returns every stored revision of a page, newest first
+func (s *wikiServer) listRevisions(ctx context.Context, title string) ([]Revision, error) {
+	rs, ok := s.store.(RevisionStore)
+	if !ok {
+		return nil, nil
+	}
+	return rs.ListRevisions(ctx, title)
+}
+
+// historyHandler lists the revisions of a page on /history/<title>
+// When a rev query parameter is given, that single revision is shown instead, and ?format=atom sends the list as an Atom feed
+func (s *wikiServer) historyHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if ts := r.URL.Query().Get("rev"); ts != "" {
+		p, err := s.loadRevision(r.Context(), title, ts)
+		if errors.Is(err, os.ErrNotExist) {
+			notFound(w, r)
+			return
+		}
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		p.HTML, err = s.renderBody(r.Context(), p)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		renderTemplate(w, r, "revision", p)
+		return
+	}
+	revs, err := s.listRevisions(r.Context(), title)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	switch format := r.URL.Query().Get("format"); format {
+	case "":
+		renderTemplate(w, r, "history", struct {
+			Title     string
+			Revisions []Revision
+		}{title, revs})
+	case "atom":
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		if err := enc.Encode(historyFeed(baseURL(r), title, revs)); err != nil {
+			slog.Error("writing Atom feed", "err", err)
+		}
+	default:
+		http.Error(w, "unknown format "+strconv.Quote(format), http.StatusBadRequest)
+	}
+}
+
+// atomFeed is an Atom document, only the parts of it we fill in
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+}
+
+// atomAuthor is required by Atom, and pages don't have one the feed can use
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+// historyFeed builds the Atom feed of a page's revisions for /history/<title>?format=atom
+// Each entry links to the diff against the revision before it, or just the revision for the first one.
+// Atom needs the feed to say when it was last updated, which is the newest revision,
+// or now for a page with no history so the empty feed is still valid
+func historyFeed(base, title string, revs []Revision) atomFeed {
+	feed := atomFeed{
+		ID:      base + "/history/" + title,
+		Title:   "History of " + title,
+		Updated: time.Now().UTC().Format(time.RFC3339Nano),
+		Author:  atomAuthor{"wiki"},
+		Link:    atomLink{base + "/history/" + title},
+	}
+	if len(revs) > 0 {
+		feed.Updated = revs[0].Time.UTC().Format(time.RFC3339Nano)
+	}
+	for _, rev := range revs {
+		link := base + "/history/" + title + "?rev=" + rev.Timestamp
+		if rev.Previous != "" {
+			link = base + "/diff/" + title + "?a=" + rev.Previous + "&b=" + rev.Timestamp
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			// Timestamps are unique within a page, so the revision link never changes and works as the id
+			ID:      base + "/history/" + title + "?rev=" + rev.Timestamp,
+			Title:   title + " at " + rev.Time.UTC().Format(time.RFC1123),
+			Updated: rev.Time.UTC().Format(time.RFC3339Nano),
+			Link:    atomLink{link},
+		})
+	}
+	return feed
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The UI strings for each language, one JSON object of key to text per file, named after the language
+// en is the fallback for a language we don't have and for any string a translation is missing
+//
+//go:embed locales/*.json
+var embeddedLocales embed.FS
+
+// defaultLang is the language used when the reader hasn't asked for one we have
+const defaultLang = "en"
+
+// langCookie is the name of the cookie a language picked with /lang is kept in
+const langCookie = "lang"
+
+// catalogs maps each language to its strings, loaded by loadLocales
+var catalogs map[string]map[string]string
+
+// loadLocales reads every language's strings from locales/
+func loadLocales() error {
+	files, err := fs.Glob(embeddedLocales, "locales/*.json")
+	if err != nil {
+		return err
+	}
+	catalogs = make(map[string]map[string]string)
+	for _, file := range files {
+		data, err := embeddedLocales.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var strs map[string]string
+		if err := json.Unmarshal(data, &strs); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		catalogs[strings.TrimSuffix(strings.TrimPrefix(file, "locales/"), ".json")] = strs
+	}
+	if _, ok := catalogs[defaultLang]; !ok {
+		return fmt.Errorf("no strings for the default language %s", defaultLang)
+	}
+	return nil
+}
+
+// languages returns every language we have strings for, in order
+func languages() []string {
+	langs := make([]string, 0, len(catalogs))
+	for l := range catalogs {
+		langs = append(langs, l)
+	}
+	slices.Sort(langs)
+	return langs
+}
+
+// translate looks up key in lang's strings, falling back to English and then to the key itself
+// With args the string is a format for fmt.Sprintf, so translations can put them wherever the language needs
+func translate(lang, key string, args ...any) string {
+	s, ok := catalogs[lang][key]
+	if !ok {
+		s, ok = catalogs[defaultLang][key]
+	}
+	if !ok {
+		s = key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(s, args...)
+	}
+	return s
+}
+
+// lang returns the language to show the reader the wiki in
+// One picked with /lang wins, otherwise it's the most preferred one in Accept-Language we have strings for.
+// A tag with a region, like de-AT, also matches the plain language
+func lang(r *http.Request) string {
+	if c, err := r.Cookie(langCookie); err == nil {
+		if _, ok := catalogs[c.Value]; ok {
+			return c.Value
+		}
+	}
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var prefs []weighted
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if tag != "" && q > 0 {
+			prefs = append(prefs, weighted{strings.ToLower(tag), q})
+		}
+	}
+	// Stable, so tags with the same weight keep the order they were sent in
+	slices.SortStableFunc(prefs, func(a, b weighted) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		}
+		return 0
+	})
+	for _, p := range prefs {
+		base, _, _ := strings.Cut(p.tag, "-")
+		if _, ok := catalogs[base]; ok {
+			return base
+		}
+	}
+	return defaultLang
+}
+
+// langHandler sets the language cookie from /lang?set=<lang> and sends the reader back to the page they came from
+func langHandler(w http.ResponseWriter, r *http.Request) {
+	l := r.URL.Query().Get("set")
+	if _, ok := catalogs[l]; !ok {
+		http.Error(w, "language must be one of "+strings.Join(languages(), ", "), http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     langCookie,
+		Value:    l,
+		Path:     pathTo("/"),
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	redirectBack(w, r)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// importResult is what the import page shows after a zip has been uploaded
+type importResult struct {
+	Imported  int
+	Warnings  []string
+	CSRFToken string
+}
+
+// importHandler shows a form for uploading a zip of pages on GET /import, and imports them on POST
+// This is the other half of /export, so the archive is expected to look like one it made:
+// a <title>.txt entry for every page. Bad entries are skipped with a warning rather than failing the whole import
+func (s *wikiServer) importHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		renderTemplate(w, r, "import", importResult{CSRFToken: csrfToken(w, r)})
+		return
+	case http.MethodPost:
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, *maxUpload)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "archive is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validCSRF(r) {
+		http.Error(w, "invalid or missing CSRF token", http.StatusForbidden)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "no file was uploaded", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	zr, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		http.Error(w, "not a zip archive: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := importResult{CSRFToken: csrfToken(w, r)}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if err := s.importEntry(r.Context(), f); err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s: %v", f.Name, err))
+			continue
+		}
+		result.Imported++
+	}
+	renderTemplate(w, r, "import", result)
+}
+
+// importEntry saves a single entry of an uploaded zip as a page
+func (s *wikiServer) importEntry(ctx context.Context, f *zip.File) error {
+	// Zip entry names come from whoever made the archive. Anything that isn't a plain relative
+	// path could end up outside the data directory, so it's turned away before we look any further
+	if path.IsAbs(f.Name) || strings.Contains(f.Name, `\`) || path.Clean(f.Name) != f.Name ||
+		f.Name == ".." || strings.HasPrefix(f.Name, "../") {
+		return errors.New("path escapes the wiki")
+	}
+	title, ok := strings.CutSuffix(f.Name, ".txt")
+	if !ok {
+		return errors.New("not a .txt file")
+	}
+	if err := validateTitle(title); err != nil {
+		return err
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	// The size in the header can't be trusted, so read one byte more than is allowed to find out
+	body, err := io.ReadAll(io.LimitReader(rc, *maxSize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > *maxSize {
+		return errors.New("page is too large")
+	}
+	if err := s.checkPageLimit(ctx, title); err != nil {
+		return err
+	}
+	p := &Page{Title: title, Body: body}
+	return s.savePage(ctx, p)
+}
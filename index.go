@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// A pageIndex is a map from some key to page titles, built by looking through every page
+// Building it means loading every page, so it's cached until a page is saved, deleted or renamed
+type pageIndex struct {
+	build func(ctx context.Context) (map[string][]string, error)
+
+	mu    sync.Mutex
+	pages map[string][]string
+	// gen is bumped on every invalidate, so a rebuild that raced with a save doesn't get cached
+	gen   int
+	built int
+}
+
+// invalidate throws away the cached index so the next lookup rebuilds it
+func (t *pageIndex) invalidate() {
+	t.mu.Lock()
+	t.gen++
+	t.mu.Unlock()
+}
+
+// get returns the index, rebuilding it if anything has changed since it was last built
+// The map returned must not be modified
+func (t *pageIndex) get(ctx context.Context) (map[string][]string, error) {
+	t.mu.Lock()
+	if t.pages != nil && t.built == t.gen {
+		pages := t.pages
+		t.mu.Unlock()
+		return pages, nil
+	}
+	gen := t.gen
+	t.mu.Unlock()
+
+	pages, err := t.build(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	if t.gen == gen {
+		t.pages, t.built = pages, gen
+	}
+	t.mu.Unlock()
+	return pages, nil
+}
+
+// rebuild builds the index straight away, whether or not anything has changed, and caches it
+// Lookups that start while it's running build their own copy rather than using the old one.
+// If two rebuilds overlap, only the one that started last is kept
+func (t *pageIndex) rebuild(ctx context.Context) (map[string][]string, error) {
+	t.mu.Lock()
+	t.gen++
+	gen := t.gen
+	t.mu.Unlock()
+
+	pages, err := t.build(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	if t.gen == gen {
+		t.pages, t.built = pages, gen
+	}
+	t.mu.Unlock()
+	return pages, nil
+}
+
+// invalidateIndexes throws away every cached index, called whenever pages change
+func (s *wikiServer) invalidateIndexes() {
+	s.tags.invalidate()
+	s.links.invalidate()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// logLevel is the least important level that gets logged, and logFormat how each line is written
+// Requests are logged at info, handler errors at error, and the file store's reads and writes at debug
+var (
+	logLevel  = flag.String("loglevel", "info", "least important messages to log: debug, info, warn or error")
+	logFormat = flag.String("logformat", "text", "how log lines are written: text for key=value pairs or json")
+)
+
+// setupLogging makes the logger picked by -loglevel and -logformat the default one
+// The standard log package's output goes through it as well, at info
+func setupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("invalid -loglevel %q, must be debug, info, warn or error", *logLevel)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch *logFormat {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid -logformat %q, must be text or json", *logFormat)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// fatal logs msg at error and exits, for startup failures the server can't run with
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// BrokenLinks is a page along with the pages it links to that don't exist
+type BrokenLinks struct {
+	Page    string
+	Targets []string
+}
+
+// brokenLinks finds every [PageName] link to a page that doesn't exist, grouped by the page it's on
+// It goes by the backlink index, which finds links with linkedTitles the same way pages are rendered,
+// so a link shown as missing on a page is exactly one listed here. Pages and their targets are both in alphabetical order
+func (s *wikiServer) brokenLinks(ctx context.Context) ([]BrokenLinks, error) {
+	index, err := s.links.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bySource := make(map[string][]string)
+	for target, sources := range index {
+		if s.pageExists(ctx, target) {
+			continue
+		}
+		for _, source := range sources {
+			bySource[source] = append(bySource[source], target)
+		}
+	}
+	broken := make([]BrokenLinks, 0, len(bySource))
+	for source, targets := range bySource {
+		slices.Sort(targets)
+		broken = append(broken, BrokenLinks{source, targets})
+	}
+	slices.SortFunc(broken, func(a, b BrokenLinks) int {
+		return strings.Compare(a.Page, b.Page)
+	})
+	return broken, nil
+}
+
+// brokenLinksHandler lists the broken links on every page on /maintenance/brokenlinks
+func (s *wikiServer) brokenLinksHandler(w http.ResponseWriter, r *http.Request) {
+	broken, err := s.brokenLinks(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	renderTemplate(w, r, "brokenlinks", broken)
+}
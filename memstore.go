@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// A MemStore keeps pages in memory, which makes it handy for tests that shouldn't touch the data directory
+// Nothing is persisted, so everything is lost when the process exits
+type MemStore struct {
+	mu       sync.RWMutex
+	pages    map[string][]byte
+	modTimes map[string]time.Time
+	created  map[string]time.Time
+}
+
+// NewMemStore returns an empty MemStore
+func NewMemStore() *MemStore {
+	return &MemStore{
+		pages:    make(map[string][]byte),
+		modTimes: make(map[string]time.Time),
+		created:  make(map[string]time.Time),
+	}
+}
+
+// notExist builds the error returned for a missing page
+// It wraps os.ErrNotExist so callers checking for a missing page work the same as with a FileStore
+func notExist(title string) error {
+	return fmt.Errorf("page %s: %w", title, os.ErrNotExist)
+}
+
+// Load returns a copy of the page so the caller can't change what is stored
+func (s *MemStore) Load(ctx context.Context, title string) (*Page, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	body, ok := s.pages[title]
+	if !ok {
+		return nil, notExist(title)
+	}
+	return &Page{Title: title, Body: slices.Clone(body), ModTime: s.modTimes[title], Created: s.created[title]}, nil
+}
+
+// Save stores a copy of the page's body
+func (s *MemStore) Save(ctx context.Context, p *Page) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A nil body and an empty one are the same page, but only the latter shows up in the map as saved
+	now := time.Now()
+	if _, ok := s.pages[p.Title]; !ok {
+		s.created[p.Title] = p.Created
+		if p.Created.IsZero() {
+			s.created[p.Title] = now
+		}
+	}
+	s.pages[p.Title] = append([]byte{}, p.Body...)
+	s.modTimes[p.Title] = now
+	return nil
+}
+
+// Delete removes the page, returning an os.ErrNotExist error if there isn't one
+func (s *MemStore) Delete(ctx context.Context, title string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pages[title]; !ok {
+		return notExist(title)
+	}
+	delete(s.pages, title)
+	delete(s.modTimes, title)
+	delete(s.created, title)
+	return nil
+}
+
+// List returns the stored titles in alphabetical order
+func (s *MemStore) List(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	titles := make([]string, 0, len(s.pages))
+	for title := range s.pages {
+		titles = append(titles, title)
+	}
+	slices.Sort(titles)
+	return titles, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics for every request, labelled with the name of the handler it went to
+// They are exposed at /metrics, which isn't counted itself
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wiki_http_requests_total",
+		Help: "Number of HTTP requests handled, by handler and status code.",
+	}, []string{"handler", "code"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wiki_http_request_duration_seconds",
+		Help:    "How long HTTP requests took to handle, by handler and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"handler", "code"})
+
+	requestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "wiki_http_requests_in_flight",
+		Help: "Number of HTTP requests currently being handled.",
+	})
+)
+
+// metricsMiddleware records the request count, duration and in-flight gauge for h under the given handler name
+func metricsMiddleware(name string, h http.Handler) http.Handler {
+	labels := prometheus.Labels{"handler": name}
+	return promhttp.InstrumentHandlerInFlight(requestsInFlight,
+		promhttp.InstrumentHandlerDuration(requestDuration.MustCurryWith(labels),
+			promhttp.InstrumentHandlerCounter(requestsTotal.MustCurryWith(labels), h)))
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gzipMinSize is the smallest response worth compressing
+// Anything smaller is sent as is since the gzip header and CPU time cost more than they save
+const gzipMinSize = 1024
+
+// acceptsGzip reports whether the client listed gzip, or *, in its Accept-Encoding header without a q of 0
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.TrimSpace(name)
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		if v, err := strconv.ParseFloat(q, 64); err == nil && v > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the response until it knows whether it is big enough to compress
+// Writes are buffered until gzipMinSize bytes have been seen. At that point the
+// headers are sent with Content-Encoding set and everything after goes through gz.
+// If the handler finishes first, the buffered response is sent uncompressed by finish.
+// ifNoneMatch is the request's If-None-Match, for giving a 304 the ETag the client has, see finish
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	buf         bytes.Buffer
+	gz          *gzip.Writer
+	ifNoneMatch string
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.status == 0 {
+		g.status = status
+	}
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	if g.gz != nil {
+		return g.gz.Write(b)
+	}
+	g.buf.Write(b)
+	if g.buf.Len() < gzipMinSize || !g.compressible() {
+		return len(b), nil
+	}
+
+	h := g.Header()
+	// net/http won't sniff the type of an encoded body, so do it here on the uncompressed bytes
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", http.DetectContentType(g.buf.Bytes()))
+	}
+	h.Set("Content-Encoding", "gzip")
+	// The length set by the handler, if any, is for the uncompressed body, and so is the ETag
+	h.Del("Content-Length")
+	if etag := h.Get("ETag"); etag != "" {
+		h.Set("ETag", gzipETag(etag))
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+	g.gz = gzip.NewWriter(g.ResponseWriter)
+	if _, err := g.gz.Write(g.buf.Bytes()); err != nil {
+		return 0, err
+	}
+	g.buf.Reset()
+	return len(b), nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// compressible reports whether the response is one that can be gzipped
+// A handler that has already encoded its body, or a response that can't have a body, is left alone
+func (g *gzipResponseWriter) compressible() bool {
+	if g.Header().Get("Content-Encoding") != "" {
+		return false
+	}
+	return g.status != http.StatusNoContent && g.status != http.StatusNotModified
+}
+
+// finish sends whatever is left once the handler has returned
+func (g *gzipResponseWriter) finish() error {
+	if g.gz != nil {
+		return g.gz.Close()
+	}
+	if g.status == 0 {
+		return nil
+	}
+	// A 304 isn't compressed, but it should carry the ETag of the copy the client has, which was if it asked with that one
+	if etag := g.Header().Get("ETag"); g.status == http.StatusNotModified && etag != "" &&
+		strings.Contains(g.ifNoneMatch, gzipETag(etag)) {
+		g.Header().Set("ETag", gzipETag(etag))
+	}
+	if g.buf.Len() > 0 && g.Header().Get("Content-Encoding") == "" {
+		g.Header().Set("Content-Length", strconv.Itoa(g.buf.Len()))
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+	_, err := g.ResponseWriter.Write(g.buf.Bytes())
+	return err
+}
+
+// gzipMiddleware compresses responses with gzip for clients that accept it
+// Only responses of at least gzipMinSize bytes are compressed, smaller ones go out untouched
+func gzipMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Caches need to know the response differs depending on Accept-Encoding
+		addVary(w.Header(), "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		// A gzipped copy the client has can only be current if the response is going to be gzipped again,
+		// so its tag is only matched here and not for clients that don't take gzip
+		inm := r.Header.Get("If-None-Match")
+		if inm != "" {
+			r = r.Clone(r.Context())
+			r.Header.Set("If-None-Match", stripGzipETags(inm))
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w, ifNoneMatch: inm}
+		h.ServeHTTP(gw, r)
+		gw.finish()
+	})
+}
+
+// statusRecorder wraps a ResponseWriter to remember the status code and how many bytes were written
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.size += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// loggingMiddleware logs one line for every request once it has been handled
+// The line is made of key=value pairs, or JSON with -logformat json, so it's easy to grep and parse
+func loggingMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			// Nothing was written, which net/http sends as an empty 200
+			rec.status = http.StatusOK
+		}
+		slog.Info("request", "request_id", requestIDFromContext(r.Context()), "method", r.Method, "path", r.URL.Path,
+			"status", rec.status, "size", rec.size, "duration", time.Since(start))
+	})
+}
+
+// noListingFS is an http.FileSystem that refuses to open directories
+// http.FileServer would otherwise list the contents of any directory it is asked for,
+// this way a directory is a plain 404 just like a missing file
+type noListingFS struct {
+	fs http.FileSystem
+}
+
+func (n noListingFS) Open(name string) (http.File, error) {
+	f, err := n.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, fs.ErrNotExist
+	}
+	return f, nil
+}
+
+// recoverMiddleware turns a panic in a handler into a 500 instead of a dropped connection
+// The stack trace is logged so the bug can be found. http.ErrAbortHandler is passed on,
+// as that's a handler deliberately aborting the response rather than a bug
+func recoverMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			slog.Error("panic serving request", "method", r.Method, "path", r.URL.Path, "err", err, "stack", string(debug.Stack()))
+			// If the handler already started the response this can't change the status,
+			// but the client at least gets a response that ends properly
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// An inFlightRequest is a request that is still being handled, see inFlightTracker
+type inFlightRequest struct {
+	ID     string
+	Method string
+	Path   string
+	Start  time.Time
+}
+
+// inFlightTracker keeps track of the requests currently being handled, so a shutdown that runs out of time
+// can say which ones it's cutting off. The gauge in metrics.go only knows how many there are
+type inFlightTracker struct {
+	mu       sync.Mutex
+	next     uint64
+	requests map[uint64]inFlightRequest
+}
+
+var inFlight = inFlightTracker{requests: make(map[uint64]inFlightRequest)}
+
+// middleware records each request for as long as h is handling it
+// It goes inside requestIDMiddleware so the request ID is known
+func (t *inFlightTracker) middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.mu.Lock()
+		t.next++
+		key := t.next
+		t.requests[key] = inFlightRequest{requestIDFromContext(r.Context()), r.Method, r.URL.Path, time.Now()}
+		t.mu.Unlock()
+		defer func() {
+			t.mu.Lock()
+			delete(t.requests, key)
+			t.mu.Unlock()
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// list returns the requests being handled right now, oldest first
+func (t *inFlightTracker) list() []inFlightRequest {
+	t.mu.Lock()
+	reqs := make([]inFlightRequest, 0, len(t.requests))
+	for _, req := range t.requests {
+		reqs = append(reqs, req)
+	}
+	t.mu.Unlock()
+	slices.SortFunc(reqs, func(a, b inFlightRequest) int {
+		return a.Start.Compare(b.Start)
+	})
+	return reqs
+}
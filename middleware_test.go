@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A client only gets a 304 for its gzipped copy of a response if the response would be gzipped for it again
+func TestGzipETag(t *testing.T) {
+	const etag = `"abc123"`
+	body := strings.Repeat("page ", gzipMinSize)
+	h := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if notModified(w, r, etag, time.Time{}) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	tests := []struct {
+		name           string
+		gzip           bool
+		ifNoneMatch    string
+		code           int
+		etag, encoding string
+	}{
+		{"gzip", true, "", http.StatusOK, gzipETag(etag), "gzip"},
+		{"gzip with gzipped copy", true, gzipETag(etag), http.StatusNotModified, gzipETag(etag), ""},
+		{"gzip with plain copy", true, etag, http.StatusNotModified, etag, ""},
+		{"gzip with other copy", true, `"other-gzip"`, http.StatusOK, gzipETag(etag), "gzip"},
+		{"plain", false, "", http.StatusOK, etag, ""},
+		{"plain with plain copy", false, etag, http.StatusNotModified, etag, ""},
+		{"plain with gzipped copy", false, gzipETag(etag), http.StatusOK, etag, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.gzip {
+				r.Header.Set("Accept-Encoding", "gzip")
+			}
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			w := serve(h, r)
+			if w.Code != tt.code {
+				t.Errorf("got %d, want %d", w.Code, tt.code)
+			}
+			if got := w.Header().Get("ETag"); got != tt.etag {
+				t.Errorf("ETag %s, want %s", got, tt.etag)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("Content-Encoding %q, want %q", got, tt.encoding)
+			}
+		})
+	}
+}
+
+// A panicking handler gets the client a 500, and the server carries on with the next request
+func TestRecoverMiddleware(t *testing.T) {
+	h := recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			panic("handler bug")
+		}
+		w.Write([]byte("fine"))
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("panic got %d, want 500", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "fine" {
+		t.Errorf("request after the panic got %d: %s", w.Code, w.Body)
+	}
+
+	// http.ErrAbortHandler is left for net/http to deal with
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", err)
+		}
+	}()
+	recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// negotiate picks the media type in offers the Accept header likes best
+// Each offer gets the q value of the most specific range in accept matching it, like text/plain over text/* over */*.
+// Ties go to whichever comes first in offers, so that's the default, and so is an empty or unparseable header.
+// If accept rules out everything, the first offer is still returned, as a page is more use than a 406
+func negotiate(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	best, bestQ := offers[0], -1.0
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+		for _, part := range strings.Split(accept, ",") {
+			mediaRange, params, _ := strings.Cut(part, ";")
+			mediaRange = strings.ToLower(strings.TrimSpace(mediaRange))
+			s := -1
+			switch {
+			case mediaRange == offer:
+				s = 2
+			case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaRange, "*")):
+				s = 1
+			case mediaRange == "*/*":
+				s = 0
+			}
+			if s <= specificity {
+				continue
+			}
+			specificity, q = s, 1
+			for _, param := range strings.Split(params, ";") {
+				if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+					if f, err := strconv.ParseFloat(v, 64); err == nil {
+						q = f
+					}
+				}
+			}
+		}
+		if q > bestQ && q > 0 {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
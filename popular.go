@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// viewsSaveInterval is how often the view counts are written to disk
+// Counts since the last write are lost if the server dies without shutting down properly
+const viewsSaveInterval = time.Minute
+
+// defaultPopular is how many pages /popular shows when the query doesn't say
+const defaultPopular = 20
+
+// viewCounter counts how many times each page has been viewed
+// Counting only touches the map under its lock, writing it out is left to save so a view never waits on the disk
+type viewCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+	// dirty is set once a view has been counted since the last save, so an idle wiki isn't written for nothing
+	dirty bool
+}
+
+var views = viewCounter{counts: make(map[string]int64)}
+
+// viewsPath is where the view counts are kept
+// Like attachments, they're always on disk under -datadir, whichever store the pages are in
+func viewsPath() string {
+	return filepath.Join(*dataDir, "views.json")
+}
+
+// count adds one view of title
+func (v *viewCounter) count(title string) {
+	v.mu.Lock()
+	v.counts[title]++
+	v.dirty = true
+	v.mu.Unlock()
+}
+
+// load reads the counts saved by an earlier run, a wiki that hasn't saved any yet starts from nothing
+func (v *viewCounter) load() error {
+	data, err := os.ReadFile(viewsPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	counts := make(map[string]int64)
+	if err := json.Unmarshal(data, &counts); err != nil {
+		return err
+	}
+	v.mu.Lock()
+	v.counts = counts
+	v.mu.Unlock()
+	return nil
+}
+
+// save writes the counts to disk if any views have been counted since the last time
+// The map is encoded under the lock but written after it's released, so views carry on while the file is written
+func (v *viewCounter) save() error {
+	v.mu.Lock()
+	if !v.dirty {
+		v.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(v.counts)
+	v.dirty = false
+	v.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*dataDir, 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(viewsPath(), data, 0600)
+}
+
+// saveEvery saves the counts every interval until stop is closed
+func (v *viewCounter) saveEvery(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := v.save(); err != nil {
+				slog.Error("saving view counts", "err", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// A PopularPage is a page along with how many times it has been viewed
+type PopularPage struct {
+	Title string
+	Views int64
+}
+
+// popular returns the n most viewed pages that still exist, most viewed first
+// Pages with the same count are in title order so the list doesn't shuffle between requests
+func (v *viewCounter) popular(n int, exists func(title string) bool) []PopularPage {
+	v.mu.Lock()
+	pages := make([]PopularPage, 0, len(v.counts))
+	for title, count := range v.counts {
+		pages = append(pages, PopularPage{title, count})
+	}
+	v.mu.Unlock()
+	slices.SortFunc(pages, func(a, b PopularPage) int {
+		if a.Views != b.Views {
+			if a.Views > b.Views {
+				return -1
+			}
+			return 1
+		}
+		if a.Title < b.Title {
+			return -1
+		}
+		return 1
+	})
+	// Deleted and renamed pages keep their counts, but aren't worth listing
+	var out []PopularPage
+	for _, p := range pages {
+		if len(out) == n {
+			break
+		}
+		if exists(p.Title) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// popularHandler lists the most viewed pages on /popular, ?n= sets how many
+func (s *wikiServer) popularHandler(w http.ResponseWriter, r *http.Request) {
+	n := defaultPopular
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		n, err = strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "n must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	exists := func(title string) bool { return s.pageExists(r.Context(), title) }
+	renderTemplate(w, r, "popular", views.popular(n, exists))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Purge flags, for scratch wikis whose pages are only wanted for a while
+// purgeAfter is how long a page can go without being saved before it's deleted, and purgeInterval how often to look
+var (
+	purgeAfter    = flag.Duration("purge-after", 0, "delete pages that haven't been saved for this long, 0 to keep them")
+	purgeInterval = flag.Duration("purge-interval", time.Hour, "how often to look for pages to delete with -purge-after")
+)
+
+// purgeIdlePages deletes every page last saved more than -purge-after ago and returns how many went
+// Pages are deleted the same way as from the delete button, so they go to the trash unless -hard-delete is set.
+// It stops early, between pages, if ctx is cancelled
+func (s *wikiServer) purgeIdlePages(ctx context.Context) (int, error) {
+	titles, err := s.listPages(ctx)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-*purgeAfter)
+	purged := 0
+	for _, title := range titles {
+		if err := ctx.Err(); err != nil {
+			return purged, err
+		}
+		p, err := s.loadPage(ctx, title)
+		if err != nil || !p.ModTime.Before(cutoff) {
+			continue
+		}
+		if err := s.deletePage(ctx, title); err != nil {
+			return purged, fmt.Errorf("purging %s: %w", title, err)
+		}
+		slog.Info("purged idle page", "title", title, "modified", p.ModTime)
+		purged++
+	}
+	return purged, nil
+}
+
+// purgeEvery runs purgeIdlePages straight away and then every interval, until ctx is cancelled
+func (s *wikiServer) purgeEvery(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if _, err := s.purgeIdlePages(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("purging idle pages", "err", err)
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+)
+
+// randomHandler redirects to a page picked at random on /random
+// The top level math/rand functions are seeded randomly when the program starts, so the picks differ every run.
+// An empty wiki has nothing to pick, so that goes back to the index with a notice instead
+func (s *wikiServer) randomHandler(w http.ResponseWriter, r *http.Request) {
+	titles, err := s.listPages(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if len(titles) == 0 {
+		http.Redirect(w, r, pathTo("/pages?notice=nopages"), http.StatusFound)
+		return
+	}
+	// Every request should pick again, so don't let anything cache the redirect
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, pathTo("/view/"+titles[rand.Intn(len(titles))]), http.StatusFound)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Rate limiting flags, each client IP may make saveRate saves a second with bursts of up to saveBurst
+var (
+	saveRate  = flag.Float64("rate", 1, "saves per second allowed from each client IP, by form or API, 0 for no limit")
+	saveBurst = flag.Int("burst", 5, "number of saves a client IP can make at once before being rate limited")
+)
+
+// How long a client has to go without a request before its limiter is thrown away,
+// and how often the limiters are checked for ones to throw away
+const (
+	limiterIdle  = 10 * time.Minute
+	limiterSweep = time.Minute
+)
+
+// clientLimiter is the token bucket for a single client IP
+// lastSeen is a unix time in nanoseconds, updated on every request without taking a lock
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen atomic.Int64
+}
+
+// A rateLimiter hands out a token bucket to every client IP it sees
+type rateLimiter struct {
+	limit   rate.Limit
+	burst   int
+	clients sync.Map
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// newRateLimiter returns a rateLimiter allowing each client limit requests a second, with bursts of burst
+func newRateLimiter(limit rate.Limit, burst int) *rateLimiter {
+	return &rateLimiter{limit: limit, burst: burst, lastSweep: time.Now()}
+}
+
+// get returns the limiter for the client at ip, creating one the first time it is seen
+func (rl *rateLimiter) get(ip string, now time.Time) *rate.Limiter {
+	v, ok := rl.clients.Load(ip)
+	if !ok {
+		v, _ = rl.clients.LoadOrStore(ip, &clientLimiter{limiter: rate.NewLimiter(rl.limit, rl.burst)})
+	}
+	c := v.(*clientLimiter)
+	c.lastSeen.Store(now.UnixNano())
+	return c.limiter
+}
+
+// sweep throws away the limiters of clients that have been idle for limiterIdle
+// It is called on every request but only does any work once every limiterSweep,
+// which keeps memory from growing forever without needing a goroutine of its own
+func (rl *rateLimiter) sweep(now time.Time) {
+	rl.mu.Lock()
+	if now.Sub(rl.lastSweep) < limiterSweep {
+		rl.mu.Unlock()
+		return
+	}
+	rl.lastSweep = now
+	rl.mu.Unlock()
+
+	cutoff := now.Add(-limiterIdle).UnixNano()
+	rl.clients.Range(func(k, v any) bool {
+		if v.(*clientLimiter).lastSeen.Load() < cutoff {
+			rl.clients.Delete(k)
+		}
+		return true
+	})
+}
+
+// clientIP returns the IP address the request came from, without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allow takes a token for the client r came from, or reports how long until it will have one
+// The client is sent a 429 if it hasn't got one, with retryAfter in Retry-After for how many seconds until it will.
+// A nil rateLimiter allows everything
+func (rl *rateLimiter) allow(r *http.Request) (retryAfter string, ok bool) {
+	if rl == nil {
+		return "", true
+	}
+	now := time.Now()
+	rl.sweep(now)
+	res := rl.get(clientIP(r), now).ReserveN(now, 1)
+	if delay := res.DelayFrom(now); !res.OK() || delay > 0 {
+		res.CancelAt(now)
+		return strconv.Itoa(int(math.Ceil(max(delay, time.Second).Seconds()))), false
+	}
+	return "", true
+}
+
+// saveLimiter returns the rateLimiter for saves, using the -rate and -burst flags
+// A -rate of 0 turns rate limiting off, which is a nil rateLimiter
+func saveLimiter() *rateLimiter {
+	if *saveRate <= 0 {
+		return nil
+	}
+	return newRateLimiter(rate.Limit(*saveRate), *saveBurst)
+}
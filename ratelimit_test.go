@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Saves through the API count towards the same limit as saves through the edit form
+func TestAPIRateLimit(t *testing.T) {
+	setFlag(t, saveRate, 1)
+	setFlag(t, saveBurst, 2)
+	_, h := newTestWiki(t)
+	put := func() *httptest.ResponseRecorder {
+		return serve(h, jsonRequest("PUT", "/api/pages/Limited", `{"body": "hello"}`))
+	}
+
+	for i := range 2 {
+		if w := put(); w.Code != http.StatusOK && w.Code != http.StatusCreated {
+			t.Fatalf("PUT %d got %d, want it saved", i+1, w.Code)
+		}
+	}
+	w := put()
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("PUT over the limit got %d, want 429 with Retry-After", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"error"`) {
+		t.Errorf("API 429 body %q isn't a JSON error", w.Body.String())
+	}
+	if w := serve(h, httptest.NewRequest("GET", "/api/pages/Limited", nil)); w.Code != http.StatusOK {
+		t.Errorf("GET after the limit got %d, reading isn't limited", w.Code)
+	}
+	if w := savePageForm(h, "Limited", "x"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("form save after API saves used up the limit got %d, want 429", w.Code)
+	}
+}
+
+// Previewing a page doesn't use up any of the saves allowed, only writing it does
+func TestPreviewNotRateLimited(t *testing.T) {
+	setFlag(t, saveRate, 1)
+	setFlag(t, saveBurst, 1)
+	_, h := newTestWiki(t)
+
+	for i := range 3 {
+		w := postForm(h, "/save/Draft", url.Values{"body": {"# Draft"}, "preview": {"1"}})
+		if w.Code != http.StatusOK {
+			t.Fatalf("preview %d got %d, want the edit form", i+1, w.Code)
+		}
+	}
+	if w := savePageForm(h, "Draft", "# Draft"); w.Code != http.StatusFound {
+		t.Errorf("save after previews got %d, want it saved", w.Code)
+	}
+	if w := savePageForm(h, "Draft", "# Again"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second save got %d, want 429", w.Code)
+	}
+}
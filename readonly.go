@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+)
+
+// readOnly turns off every way of changing the wiki, for publishing it where anyone can read it
+var readOnly = flag.Bool("readonly", false, "turn away every request that would change a page with a 403")
+
+// readOnlyMessage is what a change gets back while the wiki is read only
+const readOnlyMessage = "the wiki is read only"
+
+// readOnlyMiddleware answers with a 403 instead of calling h when -readonly is set
+// It goes outside authMiddleware, so nobody is asked for a password for something that's going to be refused anyway
+func readOnlyMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *readOnly {
+			http.Error(w, readOnlyMessage, http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// With -readonly nothing can be saved, from the edit form or the API, but pages can still be read
+func TestReadOnly(t *testing.T) {
+	s, h := newTestWiki(t)
+	addPage(t, s, "Published", "as it was")
+	setFlag(t, readOnly, true)
+
+	if w := savePageForm(h, "Published", "changed"); w.Code != http.StatusForbidden {
+		t.Errorf("form save got %d, want 403", w.Code)
+	}
+	if w := serve(h, httptest.NewRequest("GET", "/edit/Published", nil)); w.Code != http.StatusForbidden {
+		t.Errorf("edit form got %d, want 403", w.Code)
+	}
+	w := serve(h, jsonRequest("PUT", "/api/pages/Published", `{"body": "changed"}`))
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), readOnlyMessage) {
+		t.Errorf("API save got %d: %s", w.Code, w.Body)
+	}
+
+	p, err := s.loadPage(context.Background(), "Published")
+	if err != nil || string(p.Body) != "as it was" {
+		t.Errorf("page is %v, %v after the refused saves", p, err)
+	}
+	if w := serve(h, httptest.NewRequest("GET", "/view/Published", nil)); w.Code != http.StatusOK {
+		t.Errorf("view got %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"flag"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultRecent is how many pages /recent shows when the query doesn't say
+const defaultRecent = 20
+
+// A RecentChange is a page along with when it was last saved
+type RecentChange struct {
+	Title   string
+	ModTime time.Time
+}
+
+// recentChanges returns the n most recently saved pages, newest first
+// Every page is loaded to find out when it was saved, so this goes through the store like everything else
+func (s *wikiServer) recentChanges(ctx context.Context, n int) ([]RecentChange, error) {
+	titles, err := s.listPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]RecentChange, 0, len(titles))
+	for _, title := range titles {
+		p, err := s.loadPage(ctx, title)
+		if err != nil {
+			continue
+		}
+		changes = append(changes, RecentChange{title, p.ModTime})
+	}
+	slices.SortFunc(changes, func(a, b RecentChange) int {
+		return b.ModTime.Compare(a.ModTime)
+	})
+	if len(changes) > n {
+		changes = changes[:n]
+	}
+	return changes, nil
+}
+
+// rss is an RSS 2.0 document, only the parts of it we fill in
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title   string `xml:"title"`
+	Link    string `xml:"link"`
+	GUID    string `xml:"guid"`
+	PubDate string `xml:"pubDate"`
+}
+
+// publicURL is the address the wiki is reached at from outside, for when a proxy in front of it
+// means the Host it sees isn't the one readers use
+var publicURL = flag.String("baseurl", "", "URL the wiki is reached at, used for absolute links in feeds and the sitemap (default from the request)")
+
+// baseURL works out the scheme, host and -basepath the request was made to, as feed readers and crawlers need absolute links
+// -baseurl wins if it's set, and should include the base path if there is one
+func baseURL(r *http.Request) string {
+	if *publicURL != "" {
+		return strings.TrimSuffix(*publicURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + *basePath
+}
+
+// recentHandler lists the most recently changed pages on /recent
+// ?n= sets how many, and ?format=rss sends them as an RSS feed instead of a page
+func (s *wikiServer) recentHandler(w http.ResponseWriter, r *http.Request) {
+	n := defaultRecent
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		n, err = strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "n must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	changes, err := s.recentChanges(r.Context(), n)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "":
+		renderTemplate(w, r, "recent", changes)
+	case "rss":
+		base := baseURL(r)
+		feed := rss{Version: "2.0", Channel: rssChannel{
+			Title:       "Recent changes",
+			Link:        base + "/recent",
+			Description: "Pages most recently changed on the wiki",
+		}}
+		for _, c := range changes {
+			link := base + "/view/" + c.Title
+			feed.Channel.Items = append(feed.Channel.Items, rssItem{
+				Title: c.Title,
+				Link:  link,
+				// The same page saved twice is a different change, so the time is part of the guid
+				GUID:    link + "#" + strconv.FormatInt(c.ModTime.UnixNano(), 10),
+				PubDate: c.ModTime.UTC().Format(time.RFC1123Z),
+			})
+		}
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		if err := enc.Encode(feed); err != nil {
+			slog.Error("writing RSS feed", "err", err)
+		}
+	default:
+		http.Error(w, "unknown format "+strconv.Quote(format), http.StatusBadRequest)
+	}
+}
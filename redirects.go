@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// maxRedirects is how many redirects in a row viewHandler follows before giving up on the chain
+var maxRedirects = flag.Int("maxredirects", 5, "maximum number of page redirects followed in a row")
+
+// redirectBody matches a page whose whole content is #REDIRECT [Target]
+var redirectBody = regexp.MustCompile(`^(?i:#REDIRECT)\s*\[(` + titlePattern + `)\]$`)
+
+// errRedirectLoop is returned by resolveRedirects when a chain of redirects comes back to a page it already went through,
+// and errTooManyRedirects when it's longer than -maxredirects
+var (
+	errRedirectLoop     = errors.New("redirect loop")
+	errTooManyRedirects = errors.New("too many redirects")
+)
+
+// aliasesPath is the file of aliases, each line an old title and the title it now goes to, like "OldName NewName"
+// Blank lines and lines starting with # are ignored. It's kept in -datadir, whichever store the pages are in
+func aliasesPath() string {
+	return filepath.Join(*dataDir, ".aliases")
+}
+
+// loadAliases reads the alias file, a wiki without one has no aliases
+// It's read every time it's needed, which is only for pages that don't exist, so changes apply straight away
+func loadAliases() (map[string]string, error) {
+	f, err := os.Open(aliasesPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	aliases := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || validateTitle(fields[0]) != nil || validateTitle(fields[1]) != nil {
+			return nil, fmt.Errorf("%s: invalid alias %q, must be two titles", aliasesPath(), line)
+		}
+		aliases[fields[0]] = fields[1]
+	}
+	return aliases, sc.Err()
+}
+
+// redirectOf returns where the page with the given title sends readers, if anywhere
+// p and err are the result of loading it. A page redirects with a #REDIRECT body,
+// and a page that doesn't exist can be sent on by an alias. A real page always wins over an alias
+func redirectOf(title string, p *Page, err error) (string, bool, error) {
+	if err == nil {
+		m := redirectBody.FindSubmatch(bytes.TrimSpace(p.Content()))
+		if m == nil {
+			return "", false, nil
+		}
+		return string(m[1]), true, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", false, err
+	}
+	aliases, err := loadAliases()
+	if err != nil {
+		return "", false, err
+	}
+	target, ok := aliases[title]
+	return target, ok, nil
+}
+
+// resolveRedirects follows the redirects starting from title, which redirects to next, and returns the page it ends on
+// Going back to a page already visited is errRedirectLoop and taking more than -maxredirects steps is
+// errTooManyRedirects, both wrapped with the chain so far so it can be shown to whoever has to fix it
+func (s *wikiServer) resolveRedirects(ctx context.Context, title, next string) (string, error) {
+	chain := []string{title, next}
+	for {
+		if len(chain)-1 > *maxRedirects {
+			return "", fmt.Errorf("%w: %s", errTooManyRedirects, strings.Join(chain, " → "))
+		}
+		cur := chain[len(chain)-1]
+		if slices.Contains(chain[:len(chain)-1], cur) {
+			return "", fmt.Errorf("%w: %s", errRedirectLoop, strings.Join(chain, " → "))
+		}
+		p, err := s.loadPage(ctx, cur)
+		target, ok, err := redirectOf(cur, p, err)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return cur, nil
+		}
+		chain = append(chain, target)
+	}
+}
+
+// followRedirect sends the reader on to where the page redirects to, reporting whether it did
+// ?redirect=no shows the redirecting page itself instead, so it can be seen and fixed.
+// The page redirected to is told where the reader came from with ?from=
+func (s *wikiServer) followRedirect(w http.ResponseWriter, r *http.Request, title string, p *Page, err error) bool {
+	if r.URL.Query().Get("redirect") == "no" {
+		return false
+	}
+	next, ok, err := redirectOf(title, p, err)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return true
+	}
+	if !ok {
+		return false
+	}
+	final, err := s.resolveRedirects(r.Context(), title, next)
+	if errors.Is(err, errRedirectLoop) || errors.Is(err, errTooManyRedirects) {
+		http.Error(w, err.Error(), http.StatusLoopDetected)
+		return true
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return true
+	}
+	http.Redirect(w, r, pathTo("/view/"+final+"?from="+url.QueryEscape(title)), http.StatusFound)
+	return true
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestRedirects(t *testing.T) {
+	s, h := newTestWiki(t)
+	addPage(t, s, "Target", "here at last")
+	addPage(t, s, "Old", "#REDIRECT [Target]")
+	addPage(t, s, "Hop", "#redirect [Old]")
+	addPage(t, s, "Self", "#REDIRECT [Self]")
+	addPage(t, s, "Ping", "#REDIRECT [Pong]")
+	addPage(t, s, "Pong", "#REDIRECT [Ping]")
+	if err := os.WriteFile(aliasesPath(), []byte("# renamed\nGone Target\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		code     int
+		location string
+	}{
+		{"/view/Old", http.StatusFound, "/view/Target?from=Old"},
+		{"/view/Hop", http.StatusFound, "/view/Target?from=Hop"},
+		{"/view/Gone", http.StatusFound, "/view/Target?from=Gone"},
+		{"/view/Self", http.StatusLoopDetected, ""},
+		{"/view/Ping", http.StatusLoopDetected, ""},
+		{"/view/Self?redirect=no", http.StatusOK, ""},
+		{"/view/Target?from=Old", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		w := serve(h, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.code || w.Header().Get("Location") != tt.location {
+			t.Errorf("GET %s got %d to %q, want %d to %q", tt.path, w.Code, w.Header().Get("Location"), tt.code, tt.location)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// requestIDHeader is the header a request ID is read from and sent back in
+const requestIDHeader = "X-Request-ID"
+
+// requestIDKey is the context key the request ID is stored under
+type requestIDKey struct{}
+
+// requestIDMiddleware gives every request an ID that is logged with everything to do with it
+// A proxy in front of the wiki can pass its own in X-Request-ID so the logs of both can be matched up,
+// otherwise a new UUID is made. Either way it's sent back in the response
+func requestIDMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// validRequestID reports whether an ID sent by the client is safe to use
+// It ends up in the log, so it's kept short and to printable characters with no spaces
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range []byte(id) {
+		if c <= ' ' || c > '~' || c == '"' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDFromContext returns the ID of the request ctx belongs to, or "" if it doesn't have one
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// validTemplateName is what a page template's name is allowed to look like, as it becomes part of a path
+var validTemplateName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// pageTemplatePath returns the file the page template with the given name is kept in
+// Templates live in .templates under -datadir, which as a dot directory is never listed as pages
+func pageTemplatePath(name string) string {
+	return filepath.Join(*dataDir, ".templates", name+".txt")
+}
+
+// newPageBody returns what the edit form for a page that doesn't exist yet starts with
+// That's the template picked with ?template=, or the one called default. A template that isn't there,
+// or a name that couldn't be one, gives an empty form just like when there are no templates at all
+func newPageBody(r *http.Request) []byte {
+	name := r.URL.Query().Get("template")
+	if name == "" {
+		name = "default"
+	}
+	if !validTemplateName.MatchString(name) {
+		return nil
+	}
+	body, err := os.ReadFile(pageTemplatePath(name))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Error("reading page template", "template", name, "err", err)
+		}
+		return nil
+	}
+	return body
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// snippetRadius is roughly how many bytes of context are shown either side of a search match
+const snippetRadius = 40
+
+// A SearchResult is a page that matched a search, along with the text around the first match
+// The JSON tags are for /api/search
+type SearchResult struct {
+	Title   string `json:"title"`
+	Snippet string `json:"snippet"`
+}
+
+// searchPages returns every page whose body contains q, ignoring case
+// The whole body is searched, so a match on any line counts
+func (s *wikiServer) searchPages(ctx context.Context, q string) ([]SearchResult, error) {
+	// A case insensitive regexp finds the match in the original body, so the
+	// snippet offsets line up even when lowercasing would change the byte length
+	re, err := regexp.Compile("(?i)" + regexp.QuoteMeta(q))
+	if err != nil {
+		return nil, err
+	}
+	titles, err := s.listPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var results []SearchResult
+	for _, title := range titles {
+		p, err := s.loadPage(ctx, title)
+		if err != nil {
+			continue
+		}
+		loc := re.FindIndex(p.Body)
+		if loc == nil {
+			continue
+		}
+		results = append(results, SearchResult{Title: title, Snippet: snippet(p.Body, loc[0], loc[1])})
+	}
+	return results, nil
+}
+
+// snippet cuts out the text surrounding body[start:end] to show alongside a search result
+// The cut is moved onto rune boundaries so multi byte characters aren't split in half
+func snippet(body []byte, start, end int) string {
+	from := max(start-snippetRadius, 0)
+	for from > 0 && !utf8.RuneStart(body[from]) {
+		from--
+	}
+	to := min(end+snippetRadius, len(body))
+	for to < len(body) && !utf8.RuneStart(body[to]) {
+		to++
+	}
+	s := strings.Join(strings.Fields(string(body[from:to])), " ")
+	if from > 0 {
+		s = "…" + s
+	}
+	if to < len(body) {
+		s += "…"
+	}
+	return s
+}
+
+// searchHandler shows a search form on /search and, when the q query parameter is given, the pages that matched it
+// An empty query just shows the form
+func (s *wikiServer) searchHandler(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	var results []SearchResult
+	if q != "" {
+		var err error
+		results, err = s.searchPages(r.Context(), q)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	renderTemplate(w, r, "search", struct {
+		Query   string
+		Results []SearchResult
+	}{q, results})
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+)
+
+// defaultCSP only lets pages load scripts, styles and the like from the wiki itself
+// Images can come from anywhere over HTTPS so pages can still embed them.
+// Even if something gets past the Markdown renderer, the browser won't run inline scripts
+const defaultCSP = "default-src 'self'; img-src 'self' https: data:; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// csp is the Content-Security-Policy sent with every response
+var csp = flag.String("csp", defaultCSP, "Content-Security-Policy header sent with every response, empty to send none")
+
+// securityHeaders sets headers on every response that limit what a browser will do with it
+// Handlers can still set their own, e.g. attachments replace the policy with a stricter one
+func securityHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *csp != "" {
+			w.Header().Set("Content-Security-Policy", *csp)
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		h.ServeHTTP(w, r)
+	})
+}
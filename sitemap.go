@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/xml"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// sitemapNS is the namespace every sitemap has to declare
+const sitemapNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// urlset is a sitemap document, see https://www.sitemaps.org/protocol.html
+type urlset struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// sitemapHandler lists every page for search engines on /sitemap.xml
+// encoding/xml escapes the text it writes, so nothing in a URL can break the document
+func (s *wikiServer) sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	titles, err := s.listPages(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	base := baseURL(r)
+	doc := urlset{XMLNS: sitemapNS}
+	for _, title := range titles {
+		p, err := s.loadPage(r.Context(), title)
+		if err != nil {
+			continue
+		}
+		u := sitemapURL{Loc: base + "/view/" + title}
+		if !p.ModTime.IsZero() {
+			u.LastMod = p.ModTime.UTC().Format(time.RFC3339)
+		}
+		doc.URLs = append(doc.URLs, u)
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		slog.Error("writing sitemap", "err", err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteSchema is run every time the database is opened, so a new database gets its table on first run
+// updated_at and created_at are unix times in nanoseconds
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS pages (
+	title      TEXT PRIMARY KEY,
+	body       BLOB NOT NULL,
+	updated_at INTEGER NOT NULL,
+	created_at INTEGER
+)`
+
+// migrate brings a database created by an older version up to date with sqliteSchema
+// created_at was added after the table was first released. Rows from before then have it
+// NULL, and Load falls back to updated_at for them
+func migrate(db *sql.DB) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info('pages')")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == "created_at" {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE pages ADD COLUMN created_at INTEGER")
+	return err
+}
+
+// A SQLiteStore keeps every page as a row in a single SQLite database
+// It's easier to back up and move around than thousands of little files once a wiki gets big
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// OpenSQLiteStore opens, or creates, the SQLite database at path and makes sure the pages table exists
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// Close closes the underlying database
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// Load returns an os.ErrNotExist error if there is no row for the title
+func (s *SQLiteStore) Load(ctx context.Context, title string) (*Page, error) {
+	var body []byte
+	var updated, created int64
+	err := s.db.QueryRowContext(ctx, "SELECT body, updated_at, COALESCE(created_at, updated_at) FROM pages WHERE title = ?", title).
+		Scan(&body, &updated, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notExist(title)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Page{Title: title, Body: body, ModTime: time.Unix(0, updated), Created: time.Unix(0, created)}, nil
+}
+
+// Save inserts the page, or replaces its body if it is already there
+// The title is still checked with validateTitle so the same pages can exist here as in a FileStore
+func (s *SQLiteStore) Save(ctx context.Context, p *Page) error {
+	if err := validateTitle(p.Title); err != nil {
+		return err
+	}
+	body := p.Body
+	if body == nil {
+		body = []byte{}
+	}
+	now := time.Now()
+	created := p.Created
+	if created.IsZero() {
+		created = now
+	}
+	// created_at is only set by the insert, so an update leaves it alone
+	_, err := s.db.ExecContext(ctx, `INSERT INTO pages (title, body, updated_at, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (title) DO UPDATE SET body = excluded.body, updated_at = excluded.updated_at`,
+		p.Title, body, now.UnixNano(), created.UnixNano())
+	return err
+}
+
+// Delete removes the page's row, returning an os.ErrNotExist error if there wasn't one
+func (s *SQLiteStore) Delete(ctx context.Context, title string) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM pages WHERE title = ?", title)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notExist(title)
+	}
+	return nil
+}
+
+// List returns every title in alphabetical order
+func (s *SQLiteStore) List(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT title FROM pages ORDER BY title")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var titles []string
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			return nil, err
+		}
+		titles = append(titles, title)
+	}
+	return titles, rows.Err()
+}
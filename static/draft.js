@@ -0,0 +1,25 @@
+// Autosaves the edit form's body as a draft every 30 seconds while it has changes.
+// Drafts go to the URL in the form's data-draft attribute along with the form's CSRF token
+(function () {
+  var form = document.querySelector("form[data-draft]");
+  if (!form) {
+    return;
+  }
+  var body = form.elements.body;
+  var last = body.value;
+  setInterval(function () {
+    if (body.value === last) {
+      return;
+    }
+    var value = body.value;
+    fetch(form.dataset.draft, {
+      method: "POST",
+      body: new URLSearchParams({ csrf_token: form.elements.csrf_token.value, body: value }),
+      credentials: "same-origin",
+    }).then(function (res) {
+      if (res.ok) {
+        last = value;
+      }
+    });
+  }, 30000);
+})();
@@ -0,0 +1,13 @@
+// Reloads the view page when someone saves the page being viewed.
+// The page's event stream is the URL in this script tag's data-events attribute
+(function () {
+  var url = document.currentScript && document.currentScript.dataset.events;
+  if (!url || !window.EventSource) {
+    return;
+  }
+  var source = new EventSource(url);
+  source.addEventListener("saved", function () {
+    source.close();
+    location.reload();
+  });
+})();
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Store is somewhere pages are kept
+// Handlers never touch the disk themselves, they go through the store their wikiServer was made with,
+// so a different backend can be swapped in for testing or deployment.
+// Load and Delete return an error satisfying errors.Is(err, os.ErrNotExist) when there is no such page.
+// Save keeps the creation time of a page that already exists. For a new page it records p.Created,
+// or the current time if that is zero, and Load returns it in Created.
+// Every method takes the context of the request it's for, and gives up with ctx.Err() once that's done,
+// so a request the client has gone away from or that has hit -timeout doesn't go on queueing for the disk or database.
+// A read or write that's already under way isn't interrupted, only the waiting before and between them
+type Store interface {
+	Load(ctx context.Context, title string) (*Page, error)
+	Save(ctx context.Context, p *Page) error
+	Delete(ctx context.Context, title string) error
+	List(ctx context.Context) ([]string, error)
+}
+
+// A RevisionStore is a Store that also keeps a snapshot of every save
+// The history handlers only work when the configured store is one
+type RevisionStore interface {
+	Store
+	LoadRevision(ctx context.Context, title, ts string) (*Page, error)
+	ListRevisions(ctx context.Context, title string) ([]Revision, error)
+}
+
+// A Renamer is a Store that can move a page to a new title itself
+// renamePage falls back to copying and deleting the page for stores that aren't one.
+// Rename returns errPageExists if newTitle is already taken
+type Renamer interface {
+	Rename(ctx context.Context, oldTitle, newTitle string) error
+}
+
+// An Exister is a Store that can tell whether a page exists more cheaply than loading it
+// pageExists falls back to Load for stores that aren't one
+type Exister interface {
+	Exists(title string) bool
+}
+
+// filePerm is the permissions the file store gives the files it writes, as an octal number
+var filePerm = flag.String("fileperm", "0600", "octal permissions for the page files the file store writes")
+
+// compressPages has the file store gzip the pages it writes
+var compressPages = flag.Bool("compress", false, "gzip the page files the file store writes")
+
+// maxRevisions is how many revisions of each page the file store keeps in its history
+var maxRevisions = flag.Int("max-revisions", 0, "maximum number of revisions kept in each page's history, 0 to keep them all")
+
+// parseFilePerm parses the -fileperm flag
+// The server has to be able to read and write its own files, so the owner bits can't be taken away
+func parseFilePerm(s string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > 0777 {
+		return 0, fmt.Errorf("-fileperm %q must be an octal mode like 0640", s)
+	}
+	if n&0600 != 0600 {
+		return 0, fmt.Errorf("-fileperm %q must let the owner read and write", s)
+	}
+	return os.FileMode(n), nil
+}
+
+// dirPerm returns the permissions for directories holding files with the given permissions
+// Anyone who can read the files also needs to be able to get into the directories
+func dirPerm(file os.FileMode) os.FileMode {
+	return file | (file&0444)>>2
+}
+
+// openStore creates the store named by the -store flag
+func openStore(kind string) (Store, error) {
+	switch kind {
+	case "file":
+		perm, err := parseFilePerm(*filePerm)
+		if err != nil {
+			return nil, err
+		}
+		// Create the data directory up front, otherwise the first save fails with an obscure error
+		if err := os.MkdirAll(*dataDir, dirPerm(perm)); err != nil {
+			return nil, fmt.Errorf("creating data directory: %w", err)
+		}
+		s := NewFileStore(*dataDir)
+		s.HardDelete = *hardDelete
+		s.Compress = *compressPages
+		s.MaxRevisions = *maxRevisions
+		s.FileMode, s.DirMode = perm, dirPerm(perm)
+		return s, nil
+	case "sqlite":
+		return OpenSQLiteStore(*dbPath)
+	case "memory":
+		return NewMemStore(), nil
+	}
+	return nil, fmt.Errorf("unknown store %q, must be file, sqlite or memory", kind)
+}
+
+// A FileStore keeps every page as a .txt file under Dir, or a gzipped .txt.gz file with Compress
+// Nested titles are kept in subdirectories, and a snapshot of each save goes in Dir/history.
+// When a page was created is kept next to it in a .meta file, and deleted pages go to Dir/.trash.
+// Files are always replaced with writeFileAtomic, so a page on disk is never half written
+type FileStore struct {
+	Dir string
+	// HardDelete removes deleted pages for good instead of moving them to the trash
+	HardDelete bool
+	// Compress gzips each page as it's saved. Pages are read either way,
+	// so it can be turned on or off at any time and pages change over as they're next saved
+	Compress bool
+	// MaxRevisions is how many revisions of a page are kept, the oldest going as new ones are saved. 0 keeps them all
+	MaxRevisions int
+	// FileMode is the permissions every file is written with, and DirMode the permissions of the directories made for them.
+	// Files are chmodded to exactly FileMode, directories are created with DirMode and so are subject to the umask
+	FileMode os.FileMode
+	DirMode  os.FileMode
+
+	// locks guard the pages, each title hashing to one of them
+	// Saves and deletes of a page take the write lock so they are serialized, and Load
+	// takes the read lock so it never sees a file that is halfway through being written.
+	// There's a fixed number so they don't pile up with every title ever seen, the cost
+	// being that now and then two unrelated pages wait on each other
+	locks [lockStripes]sync.RWMutex
+}
+
+// lockStripes is how many locks a FileStore shares out among its pages
+const lockStripes = 256
+
+// NewFileStore returns a FileStore keeping its pages in dir
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{Dir: dir, FileMode: 0600, DirMode: 0700}
+}
+
+// lock returns the lock guarding the page with the given title
+func (s *FileStore) lock(title string) *sync.RWMutex {
+	return &s.locks[lockIndex(title)]
+}
+
+// lockIndex picks which of the locks a title uses
+func lockIndex(title string) int {
+	h := fnv.New32a()
+	h.Write([]byte(title))
+	return int(h.Sum32() % lockStripes)
+}
+
+// path returns the path on disk where the page with the given title is stored, uncompressed
+// Each segment of a nested title is a directory, so Projects/Alpha is stored in Projects/Alpha.txt
+func (s *FileStore) path(title string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(title)+".txt")
+}
+
+// gzSuffix is added to the name of a page saved with Compress
+const gzSuffix = ".gz"
+
+// find returns the file the page is actually in, the gzipped one if there is one and the plain one otherwise
+// A page that's in neither gives an error satisfying errors.Is(err, os.ErrNotExist)
+func (s *FileStore) find(title string) (string, error) {
+	filename := s.path(title)
+	if info, err := os.Stat(filename + gzSuffix); err == nil && info.Mode().IsRegular() {
+		return filename + gzSuffix, nil
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a file: %w", filename, os.ErrNotExist)
+	}
+	return filename, nil
+}
+
+// readPageFile reads a page's file, decompressing it if it's gzipped
+func readPageFile(filename string) ([]byte, error) {
+	if !strings.HasSuffix(filename, gzSuffix) {
+		return os.ReadFile(filename)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return io.ReadAll(zr)
+}
+
+// gzipBytes compresses a page body for Compress
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// metaPath returns the path of the sidecar file holding when a page was created
+func (s *FileStore) metaPath(title string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(title)+".meta")
+}
+
+// historyDir returns the directory the revisions of a page are kept in
+func (s *FileStore) historyDir(title string) string {
+	return filepath.Join(s.Dir, "history", filepath.FromSlash(title))
+}
+
+// Load reads the page with the given title from disk
+// Every method checks the title with validateTitle first, as the title becomes part of a path
+func (s *FileStore) Load(ctx context.Context, title string) (*Page, error) {
+	if err := validateTitle(title); err != nil {
+		return nil, err
+	}
+	l := s.lock(title)
+	l.RLock()
+	defer l.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	filename, err := s.find(title)
+	if err != nil {
+		return nil, err
+	}
+	body, err := readPageFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("read page", "path", filename, "bytes", len(body))
+	p := &Page{Title: title, Body: body}
+	if info, err := os.Stat(filename); err == nil {
+		p.ModTime = info.ModTime()
+	}
+	p.Created = s.created(title, p.ModTime)
+	return p, nil
+}
+
+// Exists checks for the page's file without reading it
+func (s *FileStore) Exists(title string) bool {
+	if validateTitle(title) != nil {
+		return false
+	}
+	_, err := s.find(title)
+	return err == nil
+}
+
+// created reads when a page was created from its sidecar file
+// Pages saved before creation times were recorded have no sidecar, for those
+// the best we can do is fall back to when the page was last modified
+func (s *FileStore) created(title string, modTime time.Time) time.Time {
+	b, err := os.ReadFile(s.metaPath(title))
+	if err != nil {
+		return modTime
+	}
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(b)))
+	if err != nil {
+		return modTime
+	}
+	return t
+}
+
+// Save writes the page to disk, creating directories for nested titles as needed
+// A snapshot of every save is also kept in the page's history
+func (s *FileStore) Save(ctx context.Context, p *Page) error {
+	if err := validateTitle(p.Title); err != nil {
+		return err
+	}
+	l := s.lock(p.Title)
+	l.Lock()
+	defer l.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	filename, other := s.path(p.Title), s.path(p.Title)+gzSuffix
+	data := p.Body
+	if s.Compress {
+		filename, other = other, filename
+		var err error
+		if data, err = gzipBytes(p.Body); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), s.DirMode); err != nil {
+		return err
+	}
+	_, err := s.find(p.Title)
+	isNew := errors.Is(err, os.ErrNotExist)
+	if err := writeFileAtomic(filename, data, s.FileMode); err != nil {
+		return err
+	}
+	// The page may have been saved the other way before, and that copy would now be out of date
+	if err := os.Remove(other); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if isNew {
+		created := p.Created
+		if created.IsZero() {
+			created = time.Now()
+		}
+		if err := writeFileAtomic(s.metaPath(p.Title), []byte(created.Format(time.RFC3339Nano)), s.FileMode); err != nil {
+			return err
+		}
+	}
+	return s.saveRevision(ctx, p)
+}
+
+// writeFileAtomic writes data to filename without ever leaving a partly written file behind
+// The data goes to a temporary file in the same directory first, which is then renamed over filename.
+// A rename within a filesystem is atomic, so readers see either the old file or the new one,
+// even if the process dies halfway through writing
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	// The leading dot and .tmp suffix keep the temporary file from ever being listed as a page
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // does nothing once the rename has happened
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	// Make sure the data is on disk before the rename makes it visible
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return err
+	}
+	slog.Debug("wrote file", "path", filename, "bytes", len(data))
+	return nil
+}
+
+// Delete moves the page with the given title to the trash, or removes it from disk with HardDelete
+// Its history is left alone so the page can still be looked at or brought back
+func (s *FileStore) Delete(ctx context.Context, title string) error {
+	if err := validateTitle(title); err != nil {
+		return err
+	}
+	l := s.lock(title)
+	l.Lock()
+	defer l.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !s.HardDelete {
+		return s.moveToTrash(title)
+	}
+	filename, err := s.find(title)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filename); err != nil {
+		return err
+	}
+	slog.Debug("removed page", "path", filename)
+	// A page created again later with the same title is a new page with its own creation time
+	if err := os.Remove(s.metaPath(title)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List walks Dir and returns the title of every page stored in it
+// Pages in subdirectories get nested titles like Projects/Alpha.
+// Anything that isn't a .txt or .txt.gz file with a valid title is skipped so stray files don't show up as pages,
+// and the reserved directories aren't looked in at all
+func (s *FileStore) List(ctx context.Context) ([]string, error) {
+	var titles []string
+	err := filepath.WalkDir(s.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// A big wiki takes a while to walk, so stop as soon as the request is given up on
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.Dir, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// Directories starting with a dot, like the trash, never hold live pages
+			if slices.Contains(reservedDirs, rel) || (rel != "." && strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		title, ok := strings.CutSuffix(strings.TrimSuffix(filepath.ToSlash(rel), gzSuffix), ".txt")
+		// Both files of a page can be there for a moment while Save swaps one for the other.
+		// The walk is in name order, so they come one after the other and only the last title needs checking
+		if ok && validateTitle(title) == nil && (len(titles) == 0 || titles[len(titles)-1] != title) {
+			titles = append(titles, title)
+		}
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return titles, err
+}
+
+// Rename moves a page, along with its history, to a new title
+// Both pages' locks are taken in the order they're kept in, so two renames going opposite ways can't deadlock.
+// The titles might share a lock, in which case it's only taken once
+func (s *FileStore) Rename(ctx context.Context, oldTitle, newTitle string) error {
+	if err := validateTitle(oldTitle); err != nil {
+		return err
+	}
+	if err := validateTitle(newTitle); err != nil {
+		return err
+	}
+	if oldTitle == newTitle {
+		return errPageExists
+	}
+	first, second := lockIndex(oldTitle), lockIndex(newTitle)
+	if second < first {
+		first, second = second, first
+	}
+	s.locks[first].Lock()
+	defer s.locks[first].Unlock()
+	if second != first {
+		s.locks[second].Lock()
+		defer s.locks[second].Unlock()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	src, err := s.find(oldTitle)
+	if err != nil {
+		return err
+	}
+	if _, err := s.find(newTitle); err == nil {
+		return errPageExists
+	}
+	// The page keeps its compression, the gzipped file stays gzipped under the new name
+	dst := s.path(newTitle) + strings.TrimPrefix(src, s.path(oldTitle))
+	if err := os.MkdirAll(filepath.Dir(dst), s.DirMode); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	slog.Debug("moved page", "from", src, "to", dst)
+	if err := os.Rename(s.metaPath(oldTitle), s.metaPath(newTitle)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return moveFiles(s.historyDir(oldTitle), s.historyDir(newTitle), s.DirMode)
+}
+
+// moveFiles moves the files directly inside src into dst, creating dst with perm if needed
+// Subdirectories are left where they are, as they belong to pages nested under the title
+// rather than the title itself. dst may already have files in it, e.g. the history of a
+// page that was deleted, in which case the two sets are merged.
+// It's fine for src not to exist, there's just nothing to move
+func moveFiles(src, dst string, perm os.FileMode) error {
+	entries, err := os.ReadDir(src)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, perm); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if err := os.Rename(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+			return err
+		}
+	}
+	// Only succeeds if nothing was left behind, which is what we want
+	os.Remove(src)
+	return nil
+}
+
+// saveRevision writes a timestamped copy of the page to its history directory
+// Every save gets its own file so a normal save never removes an older revision.
+// Nanoseconds are used so two saves within the same second don't overwrite each other
+func (s *FileStore) saveRevision(ctx context.Context, p *Page) error {
+	dir := s.historyDir(p.Title)
+	if err := os.MkdirAll(dir, s.DirMode); err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := writeFileAtomic(filepath.Join(dir, ts+".txt"), p.Body, s.FileMode); err != nil {
+		return err
+	}
+	return s.pruneRevisions(ctx, p.Title)
+}
+
+// pruneRevisions removes the oldest revisions of a page beyond MaxRevisions
+// ListRevisions has them newest first by the timestamps in their filenames, so everything after the first MaxRevisions goes
+func (s *FileStore) pruneRevisions(ctx context.Context, title string) error {
+	if s.MaxRevisions <= 0 {
+		return nil
+	}
+	revs, err := s.ListRevisions(ctx, title)
+	if err != nil || len(revs) <= s.MaxRevisions {
+		return err
+	}
+	for _, rev := range revs[s.MaxRevisions:] {
+		err := os.Remove(filepath.Join(s.historyDir(title), rev.Timestamp+".txt"))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		slog.Debug("pruned revision", "title", title, "revision", rev.Timestamp)
+	}
+	return nil
+}
+
+// LoadRevision reads a single snapshot of a page from its history
+func (s *FileStore) LoadRevision(ctx context.Context, title, ts string) (*Page, error) {
+	if err := validateTitle(title); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	t, err := parseTimestamp(ts)
+	if err != nil {
+		return nil, err
+	}
+	body, err := os.ReadFile(filepath.Join(s.historyDir(title), ts+".txt"))
+	if err != nil {
+		return nil, err
+	}
+	return &Page{Title: title, Body: body, ModTime: t}, nil
+}
+
+// ListRevisions returns every stored revision of a page, newest first
+// A page that has never been saved has no history, which isn't treated as an error
+func (s *FileStore) ListRevisions(ctx context.Context, title string) ([]Revision, error) {
+	if err := validateTitle(title); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(s.historyDir(title))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var revs []Revision
+	for _, e := range entries {
+		ts, ok := strings.CutSuffix(e.Name(), ".txt")
+		if e.IsDir() || !ok {
+			continue
+		}
+		t, err := parseTimestamp(ts)
+		if err != nil {
+			continue
+		}
+		revs = append(revs, Revision{Title: title, Timestamp: ts, Time: t})
+	}
+	slices.SortFunc(revs, func(a, b Revision) int {
+		return b.Time.Compare(a.Time)
+	})
+	for i := 0; i+1 < len(revs); i++ {
+		revs[i].Previous = revs[i+1].Timestamp
+	}
+	return revs, nil
+}
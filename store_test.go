@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// Saves racing on the same page must leave exactly one of them on disk, never a mix, and readers must never see half a page
+func TestConcurrentSaves(t *testing.T) {
+	st := NewFileStore(t.TempDir())
+	ctx := context.Background()
+	bodies := make(map[string]bool)
+	for i := range 20 {
+		bodies[string(bytes.Repeat([]byte{byte('a' + i)}, 64<<10))] = true
+	}
+	var wg sync.WaitGroup
+	for body := range bodies {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := st.Save(ctx, &Page{Title: "Contended", Body: []byte(body)}); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			p, err := st.Load(ctx, "Contended")
+			if err == nil && !bodies[string(p.Body)] {
+				t.Errorf("Load saw a body of %d bytes that no save wrote", len(p.Body))
+			}
+		}()
+	}
+	wg.Wait()
+
+	p, err := st.Load(ctx, "Contended")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bodies[string(p.Body)] {
+		t.Errorf("final page is %d bytes that no save wrote", len(p.Body))
+	}
+}
+
+// Two titles hashing to the same lock can still be renamed one to the other without deadlocking
+func TestRenameSharedLock(t *testing.T) {
+	st := NewFileStore(t.TempDir())
+	ctx := context.Background()
+	var other string
+	for i := 0; other == ""; i++ {
+		if title := fmt.Sprintf("Page%d", i); title != "Start" && lockIndex(title) == lockIndex("Start") {
+			other = title
+		}
+	}
+	if err := st.Save(ctx, &Page{Title: "Start", Body: []byte("body")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Rename(ctx, "Start", other); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := st.Load(ctx, other); err != nil || string(p.Body) != "body" {
+		t.Errorf("after the rename Load(%q) = %v, %v", other, p, err)
+	}
+}
+
+// Pages saved through the wiki end up in the directory given with -datadir
+func TestSaveToDataDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "custom")
+	setFlag(t, dataDir, dir)
+	st, err := openStore("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newWikiServer(st).routes()
+
+	if w := savePageForm(h, "Custom", "kept in the custom directory"); w.Code != http.StatusFound {
+		t.Fatalf("save got %d: %s", w.Code, w.Body)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "Custom.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "kept in the custom directory" {
+		t.Errorf("Custom.txt has %q", b)
+	}
+}
+
+// writeFileAtomic replaces the file with exactly what it was given and never leaves its temporary file behind,
+// whether it succeeds or not
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "Page.txt")
+	if err := os.WriteFile(filename, []byte("old contents that are longer"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(filename, []byte("new"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil || string(b) != "new" {
+		t.Errorf("file has %q, %v, want exactly the new contents", b, err)
+	}
+
+	// Renaming over a directory fails, after the temporary file has been written
+	if err := os.Mkdir(filepath.Join(dir, "Dir.txt"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "Dir.txt"), []byte("new"), 0600); err == nil {
+		t.Error("writing over a directory succeeded")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != "Page.txt" && e.Name() != "Dir.txt" {
+			t.Errorf("%s was left behind", e.Name())
+		}
+	}
+}
+
+// Every file the file store writes gets exactly the -fileperm mode, whatever the umask
+func TestFilePerm(t *testing.T) {
+	dir := t.TempDir()
+	setFlag(t, dataDir, dir)
+	setFlag(t, filePerm, "0640")
+	st, err := openStore("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	// The second save puts the first in the history, so there's a snapshot to check too
+	for _, body := range []string{"first", "second"} {
+		if err := st.Save(ctx, &Page{Title: "Shared", Body: []byte(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := 0
+	err = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Mode().Perm() != 0640 {
+			t.Errorf("%s has mode %o, want 640", path, info.Mode().Perm())
+		}
+		files++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files < 2 {
+		t.Errorf("only found %d files", files)
+	}
+
+	for _, bad := range []string{"rw-r-----", "0999", "01777", "0440"} {
+		if _, err := parseFilePerm(bad); err == nil {
+			t.Errorf("parseFilePerm(%q) succeeded", bad)
+		}
+	}
+}
+
+// Pages come back exactly as they were saved with -compress on or off, and whichever way they were last saved
+func TestCompressRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	st := NewFileStore(dir)
+	ctx := context.Background()
+	body := append([]byte("---\nformat: markdown\n---\n# Héllo\n\n"), bytes.Repeat([]byte("compressible line\n"), 500)...)
+	body = append(body, 0xff, 0x00, '\n')
+
+	check := func(when string, compressed bool) {
+		t.Helper()
+		p, err := st.Load(ctx, "Packed")
+		if err != nil {
+			t.Fatalf("%s: %v", when, err)
+		}
+		if !bytes.Equal(p.Body, body) {
+			t.Errorf("%s: loaded %d bytes that differ from the %d saved", when, len(p.Body), len(body))
+		}
+		gz, err := os.ReadFile(filepath.Join(dir, "Packed.txt.gz"))
+		if compressed && (err != nil || !bytes.HasPrefix(gz, []byte{0x1f, 0x8b}) || len(gz) >= len(body)) {
+			t.Errorf("%s: Packed.txt.gz isn't the gzipped page: %v", when, err)
+		}
+		if !compressed && !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: the out of date Packed.txt.gz was left behind", when)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "Packed.txt")); compressed != errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: Packed.txt has %v", when, err)
+		}
+	}
+
+	st.Compress = true
+	if err := st.Save(ctx, &Page{Title: "Packed", Body: body}); err != nil {
+		t.Fatal(err)
+	}
+	check("saved compressed", true)
+
+	st.Compress = false
+	check("compress turned off", true)
+	if err := st.Save(ctx, &Page{Title: "Packed", Body: body}); err != nil {
+		t.Fatal(err)
+	}
+	check("saved uncompressed", false)
+
+	st.Compress = true
+	check("compress turned back on", false)
+	if err := st.Save(ctx, &Page{Title: "Packed", Body: body}); err != nil {
+		t.Fatal(err)
+	}
+	check("saved compressed again", true)
+}
+
+// Past MaxRevisions a page's oldest revisions go, leaving its newest ones and other pages' alone
+func TestMaxRevisions(t *testing.T) {
+	st := NewFileStore(t.TempDir())
+	st.MaxRevisions = 3
+	ctx := context.Background()
+	for i := 1; i <= 5; i++ {
+		if err := st.Save(ctx, &Page{Title: "Busy", Body: fmt.Appendf(nil, "v%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.Save(ctx, &Page{Title: "Quiet", Body: []byte("only")}); err != nil {
+		t.Fatal(err)
+	}
+
+	revs, err := st.ListRevisions(ctx, "Busy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, rev := range revs {
+		p, err := st.LoadRevision(ctx, "Busy", rev.Timestamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(p.Body))
+	}
+	if fmt.Sprint(got) != "[v5 v4 v3]" {
+		t.Errorf("revisions left are %v, want [v5 v4 v3]", got)
+	}
+	entries, err := os.ReadDir(st.historyDir("Busy"))
+	if err != nil || len(entries) != 3 {
+		t.Errorf("history directory has %d files, %v, want 3", len(entries), err)
+	}
+	if revs, err := st.ListRevisions(ctx, "Quiet"); err != nil || len(revs) != 1 {
+		t.Errorf("other page has %d revisions, %v", len(revs), err)
+	}
+}
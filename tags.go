@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// buildTagIndex loads every page and collects the tags from their front matter, for the tags index,
+// which maps every tag to the titles of the pages carrying it
+func (s *wikiServer) buildTagIndex(ctx context.Context) (map[string][]string, error) {
+	titles, err := s.listPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pages := make(map[string][]string)
+	for _, title := range titles {
+		p, err := s.loadPage(ctx, title)
+		if err != nil {
+			continue
+		}
+		for _, tag := range p.Tags {
+			if tag = strings.TrimSpace(tag); tag != "" && !slices.Contains(pages[tag], title) {
+				pages[tag] = append(pages[tag], title)
+			}
+		}
+	}
+	for _, titles := range pages {
+		slices.Sort(titles)
+	}
+	return pages, nil
+}
+
+// A TagCount is a tag along with how many pages carry it
+type TagCount struct {
+	Name  string
+	Count int
+}
+
+// tagsHandler lists every tag with a count of its pages on /tags,
+// and the pages carrying a single tag on /tags/<tag>
+func (s *wikiServer) tagsHandler(w http.ResponseWriter, r *http.Request) {
+	index, err := s.tags.get(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	tag := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/tags"), "/")
+	if tag != "" {
+		titles, ok := index[tag]
+		if !ok {
+			notFound(w, r)
+			return
+		}
+		renderTemplate(w, r, "tag", struct {
+			Tag    string
+			Titles []string
+		}{tag, titles})
+		return
+	}
+
+	counts := make([]TagCount, 0, len(index))
+	for name, titles := range index {
+		counts = append(counts, TagCount{name, len(titles)})
+	}
+	slices.SortFunc(counts, func(a, b TagCount) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	renderTemplate(w, r, "tags", counts)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// themeCookie is the name of the cookie the reader's chosen theme is kept in
+const themeCookie = "theme"
+
+// themes are the themes a reader can pick, the first being the default
+var themes = []string{"light", "dark"}
+
+// theme returns the theme the reader picked, or the default if they haven't picked a valid one
+func theme(r *http.Request) string {
+	if c, err := r.Cookie(themeCookie); err == nil && slices.Contains(themes, c.Value) {
+		return c.Value
+	}
+	return themes[0]
+}
+
+// themeHandler sets the theme cookie from /theme?set=<theme> and sends the reader back to the page they came from
+func themeHandler(w http.ResponseWriter, r *http.Request) {
+	t := r.URL.Query().Get("set")
+	if !slices.Contains(themes, t) {
+		http.Error(w, "theme must be one of "+strings.Join(themes, ", "), http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     themeCookie,
+		Value:    t,
+		Path:     pathTo("/"),
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	redirectBack(w, r)
+}
+
+// redirectBack sends the reader back to the page they came from, or to the front page if that's unknown
+// The Referer is only followed if it's on this server, so the handlers using this can't be used to redirect anywhere else
+func redirectBack(w http.ResponseWriter, r *http.Request) {
+	back := pathTo("/")
+	if ref, err := url.Parse(r.Referer()); err == nil && ref.Host == r.Host && strings.HasPrefix(ref.Path, "/") {
+		back = ref.Path
+		if ref.RawQuery != "" {
+			back += "?" + ref.RawQuery
+		}
+	}
+	http.Redirect(w, r, back, http.StatusFound)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Timeout flags, so a slow or stuck client or request can't hold on to a connection forever
+// readTimeout and writeTimeout cover reading the whole request and writing the whole response,
+// idleTimeout is how long a keep-alive connection can sit between requests, and handlerTimeout
+// is how long a handler gets before the client is sent a 503 instead.
+// shutdownTimeout is how long requests still going when the server is told to stop get to finish
+var (
+	readTimeout     = flag.Duration("readtimeout", 15*time.Second, "maximum time to read a request, 0 for no limit")
+	writeTimeout    = flag.Duration("writetimeout", 30*time.Second, "maximum time to write a response, 0 for no limit")
+	idleTimeout     = flag.Duration("idletimeout", 2*time.Minute, "maximum time to keep an idle connection open, 0 for no limit")
+	handlerTimeout  = flag.Duration("handlertimeout", 20*time.Second, "maximum time a request can take to handle, 0 for no limit")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for requests to finish when shutting down")
+)
+
+// newServer returns the server for handler, listening on -addr with the timeouts from the flags
+// and the protocols asked for with -h2c
+func newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         *addr,
+		Handler:      handler,
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+		IdleTimeout:  *idleTimeout,
+		Protocols:    protocols(),
+	}
+}
+
+// shutdown stops the server, giving the requests still going -shutdown-timeout to finish
+// Any that haven't by then are logged and have their connections closed
+func shutdown(server *http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	for _, req := range inFlight.list() {
+		slog.Warn("request still in flight at shutdown", "request_id", req.ID, "method", req.Method, "path", req.Path, "duration", time.Since(req.Start))
+	}
+	slog.Warn("shutdown timed out, closing connections", "timeout", *shutdownTimeout)
+	server.Close()
+	return nil
+}
+
+// streamedPaths are written out as they're made, as they can be as big as the whole wiki.
+// timeoutMiddleware leaves them alone so they aren't held in memory, and only -writetimeout applies
+var streamedPaths = map[string]bool{
+	"/export":     true,
+	"/api/export": true,
+}
+
+// streamed reports whether the response to path is written out as it's made rather than all at once
+// That's streamedPaths, and the event streams on /events/, which stay open for as long as a page is
+func streamed(path string) bool {
+	return streamedPaths[path] || strings.HasPrefix(path, "/events/")
+}
+
+// timeoutMiddleware gives every request a deadline of -handlertimeout
+// The request's context is cancelled when it runs out, and the client gets a 503 straight away
+// even if the handler is still stuck waiting on the disk. The store gives up on a cancelled context
+// before each read or write, so a timed out handler stops soon after, once whatever it's in the middle of is done.
+// The response is buffered until the handler finishes, so it has to stay below -writetimeout to ever be sent
+func timeoutMiddleware(next http.Handler) http.Handler {
+	if *handlerTimeout <= 0 {
+		return next
+	}
+	timed := http.TimeoutHandler(next, *handlerTimeout, "request timed out")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if streamed(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		timed.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNewServerTimeouts(t *testing.T) {
+	server := newServer(http.NotFoundHandler())
+	if server.ReadTimeout <= 0 || server.WriteTimeout <= 0 || server.IdleTimeout <= 0 {
+		t.Errorf("server has timeouts read %v, write %v, idle %v, want them all set", server.ReadTimeout, server.WriteTimeout, server.IdleTimeout)
+	}
+}
+
+func TestStoreGivesUpOnCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for name, st := range map[string]Store{"file": NewFileStore(t.TempDir()), "memory": NewMemStore()} {
+		t.Run(name, func(t *testing.T) {
+			if err := st.Save(ctx, &Page{Title: "Cancelled", Body: []byte("body")}); !errors.Is(err, context.Canceled) {
+				t.Fatalf("Save with a cancelled context returned %v, want context.Canceled", err)
+			}
+			if _, err := st.Load(context.Background(), "Cancelled"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("page was saved anyway, Load returned %v", err)
+			}
+		})
+	}
+}
+
+// A request that times out has to keep its -maxconcurrent slot until its handler has actually returned
+func TestTimedOutRequestKeepsSlot(t *testing.T) {
+	setFlag(t, maxConcurrent, 1)
+	setFlag(t, handlerTimeout, 50*time.Millisecond)
+	release := make(chan struct{})
+	done := make(chan struct{})
+	h := timeoutMiddleware(concurrencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stuck" {
+			<-release
+			close(done)
+		}
+	})))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/stuck", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("stuck request got %d, want 503", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("request while the stuck one is still running got %d, want a busy 503", rec.Code)
+	}
+
+	close(release)
+	<-done
+	// The slot is given back in a defer once the handler returns, so give that a moment
+	deadline := time.Now().Add(time.Second)
+	for {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code == http.StatusOK || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if rec.Code != http.StatusOK {
+		t.Errorf("request after the stuck one finished got %d, want 200", rec.Code)
+	}
+}
+
+// The server starts on whatever port it's given and shuts down cleanly with nothing in flight
+func TestServerShutdown(t *testing.T) {
+	_, h := newTestWiki(t)
+	server := newServer(h)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- server.Serve(ln) }()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("/healthz got %d", resp.StatusCode)
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown returned %v", err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Serve returned %v, want http.ErrServerClosed", err)
+	}
+}
+
+// Shutdown waits -shutdown-timeout for a stuck request and no longer, then cuts it off
+func TestShutdownTimeout(t *testing.T) {
+	setFlag(t, shutdownTimeout, 200*time.Millisecond)
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	server := newServer(inFlight.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+
+	clientErr := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/stuck")
+		if err == nil {
+			resp.Body.Close()
+		}
+		clientErr <- err
+	}()
+	<-started
+
+	start := time.Now()
+	if err := shutdown(server); err != nil {
+		t.Errorf("shutdown returned %v", err)
+	}
+	if took := time.Since(start); took < *shutdownTimeout || took > *shutdownTimeout+2*time.Second {
+		t.Errorf("shutdown took %v with a timeout of %v", took, *shutdownTimeout)
+	}
+	if err := <-clientErr; err == nil {
+		t.Error("the stuck request got a response, want its connection closed")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+)
+
+// TLS flags, when -tls is set the server only speaks HTTPS using the given certificate and key
+var (
+	useTLS   = flag.Bool("tls", false, "serve HTTPS instead of HTTP")
+	certFile = flag.String("cert", "", "TLS certificate file, required with -tls")
+	keyFile  = flag.String("key", "", "TLS private key file, required with -tls")
+)
+
+// useH2C serves HTTP/2 without TLS, for running behind a proxy that has already terminated it
+// HTTP/2 over TLS needs nothing extra, net/http offers it to every client that supports it
+var useH2C = flag.Bool("h2c", false, "accept HTTP/2 without TLS (h2c), for behind a proxy that terminates TLS")
+
+// hstsMaxAge is how long, in seconds, browsers are told to only use HTTPS for the wiki (two years)
+const hstsMaxAge = 63072000
+
+// checkTLS makes sure the certificate and key given with -tls can be used
+// It runs before the server starts listening so a bad setup is reported straight away,
+// instead of as an error from ListenAndServeTLS after the port has been bound
+func checkTLS() error {
+	if *certFile == "" || *keyFile == "" {
+		return errors.New("-tls needs both -cert and -key")
+	}
+	if _, err := tls.LoadX509KeyPair(*certFile, *keyFile); err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	return nil
+}
+
+// protocols returns what the server speaks, or nil for net/http's defaults
+// With -h2c that's HTTP/2 without TLS as well as HTTP/1. net/http serves those HTTP/2 connections
+// itself, so they get -idletimeout and are waited for by Shutdown like any other.
+// Clients have to start with HTTP/2 straight away, an HTTP/1 request can't be upgraded to it
+func protocols() *http.Protocols {
+	if !*useH2C {
+		return nil
+	}
+	p := new(http.Protocols)
+	p.SetHTTP1(true)
+	p.SetUnencryptedHTTP2(true)
+	return p
+}
+
+// hstsMiddleware tells browsers to only ever reach the wiki over HTTPS from now on
+// It should only be used when the server is actually serving TLS
+func hstsMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d; includeSubDomains", hstsMaxAge))
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// With -h2c a client can use the wiki over HTTP/2 without TLS, and shutting down waits for its requests to finish
+func TestH2C(t *testing.T) {
+	setFlag(t, useH2C, true)
+	s, wiki := newTestWiki(t)
+	addPage(t, s, "Home", "over h2c")
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle("/", wiki)
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(w, r.Proto)
+	})
+	srv := httptest.NewUnstartedServer(mux)
+	srv.Config = newServer(mux)
+	srv.Start()
+	defer srv.Close()
+
+	var p http.Protocols
+	p.SetUnencryptedHTTP2(true)
+	client := &http.Client{
+		Transport: &http.Transport{Protocols: &p},
+		// The save's redirect is checked rather than followed
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	do := func(r *http.Request) (*http.Response, string) {
+		t.Helper()
+		r.AddCookie(testSession)
+		resp, err := client.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.ProtoMajor != 2 {
+			t.Errorf("%s %s went over %s, want HTTP/2", r.Method, r.URL.Path, resp.Proto)
+		}
+		return resp, string(b)
+	}
+	view := func() string {
+		t.Helper()
+		r, _ := http.NewRequest("GET", srv.URL+"/view/Home", nil)
+		resp, body := do(r)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("view got %d", resp.StatusCode)
+		}
+		return body
+	}
+
+	if body := view(); !strings.Contains(body, "over h2c") {
+		t.Errorf("view doesn't show the page: %s", body)
+	}
+	form := url.Values{"body": {"saved over h2c"}, csrfField: {testSession.Value}}
+	r, _ := http.NewRequest("POST", srv.URL+"/save/Home", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if resp, body := do(r); resp.StatusCode != http.StatusFound {
+		t.Fatalf("save got %d: %s", resp.StatusCode, body)
+	}
+	if body := view(); !strings.Contains(body, "saved over h2c") {
+		t.Errorf("view doesn't show the saved page: %s", body)
+	}
+
+	type result struct {
+		proto string
+		err   error
+	}
+	slow := make(chan result)
+	go func() {
+		resp, err := client.Get(srv.URL + "/slow")
+		if err != nil {
+			slow <- result{"", err}
+			return
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		slow <- result{string(b), err}
+	}()
+	<-started
+	if err := srv.Config.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown returned %v", err)
+	}
+	if r := <-slow; r.err != nil || r.proto != "HTTP/2.0" {
+		t.Errorf("request running during shutdown got %q, %v, want it to finish", r.proto, r.err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// A TOCEntry is a heading in a page's table of contents
+// ID is the id goldmark gave the heading when rendering it, so #ID links to it
+type TOCEntry struct {
+	Level int
+	ID    string
+	Text  string
+}
+
+// buildTOC returns the level 2 and 3 headings of a Markdown body, in the order they appear
+// The body is parsed by the same converter the page is rendered with, so the ids match the rendered headings.
+// goldmark already gives headings with the same text ids like section, section-1 and so on, so each link goes to the right one
+func buildTOC(body []byte) []TOCEntry {
+	doc := markdown.Parser().Parse(text.NewReader(body))
+	var toc []TOCEntry
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		h, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		if h.Level == 2 || h.Level == 3 {
+			if id, ok := h.AttributeString("id"); ok {
+				toc = append(toc, TOCEntry{Level: h.Level, ID: string(id.([]byte)), Text: nodeText(h, body)})
+			}
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	return toc
+}
+
+// nodeText returns the plain text inside a node, without any of the Markdown formatting around it
+func nodeText(n ast.Node, source []byte) string {
+	var buf bytes.Buffer
+	ast.Walk(n, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Text:
+			buf.Write(n.Segment.Value(source))
+			if n.SoftLineBreak() {
+				buf.WriteByte(' ')
+			}
+		case *ast.String:
+			buf.Write(n.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return buf.String()
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hardDelete turns the trash off, so deleting a page removes it for good straight away
+var hardDelete = flag.Bool("hard-delete", false, "delete pages permanently instead of moving them to the trash")
+
+// A TrashStore is a Store that moves deleted pages somewhere they can be brought back from
+// Restore returns errPageExists if a page with the title has been created since,
+// and an error satisfying errors.Is(err, os.ErrNotExist) if there's no such deleted page
+type TrashStore interface {
+	ListTrash(ctx context.Context) ([]TrashedPage, error)
+	Restore(ctx context.Context, title, ts string) error
+}
+
+// A TrashedPage is a page that was deleted and can still be restored
+// Timestamp is the unix time in nanoseconds it was deleted, which tells apart the same title deleted more than once
+type TrashedPage struct {
+	Title     string
+	Timestamp string
+	Time      time.Time
+}
+
+// trashDir is where a FileStore keeps deleted pages
+// The leading dot means no title can ever collide with it
+func (s *FileStore) trashDir() string {
+	return filepath.Join(s.Dir, ".trash")
+}
+
+// trashPath returns where a page deleted at ts is kept, with the extension left off so the .meta can go next to it
+// Nested titles keep their directories, so Projects/Alpha deleted at ts is .trash/Projects/Alpha.<ts>
+func (s *FileStore) trashPath(title, ts string) string {
+	return filepath.Join(s.trashDir(), filepath.FromSlash(title)+"."+ts)
+}
+
+// moveToTrash moves a page and its creation time into the trash
+// The caller must hold the page's lock
+func (s *FileStore) moveToTrash(title string) error {
+	src, err := s.find(title)
+	if err != nil {
+		return err
+	}
+	dst := s.trashPath(title, strconv.FormatInt(time.Now().UnixNano(), 10))
+	if err := os.MkdirAll(filepath.Dir(dst), s.DirMode); err != nil {
+		return err
+	}
+	// A gzipped page stays gzipped in the trash, as .txt.gz
+	ext := strings.TrimPrefix(src, strings.TrimSuffix(s.path(title), ".txt"))
+	if err := os.Rename(src, dst+ext); err != nil {
+		return err
+	}
+	slog.Debug("moved page to the trash", "from", src, "to", dst+ext)
+	if err := os.Rename(s.metaPath(title), dst+".meta"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ListTrash returns every page in the trash, most recently deleted first
+func (s *FileStore) ListTrash(ctx context.Context) ([]TrashedPage, error) {
+	var pages []TrashedPage
+	err := filepath.WalkDir(s.trashDir(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.trashDir(), path)
+		if err != nil {
+			return err
+		}
+		name, ok := strings.CutSuffix(strings.TrimSuffix(filepath.ToSlash(rel), gzSuffix), ".txt")
+		if !ok {
+			return nil
+		}
+		i := strings.LastIndexByte(name, '.')
+		if i < 0 {
+			return nil
+		}
+		title, ts := name[:i], name[i+1:]
+		t, err := parseTimestamp(ts)
+		if err != nil || validateTitle(title) != nil {
+			return nil
+		}
+		pages = append(pages, TrashedPage{Title: title, Timestamp: ts, Time: t})
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	slices.SortFunc(pages, func(a, b TrashedPage) int {
+		return b.Time.Compare(a.Time)
+	})
+	return pages, err
+}
+
+// Restore moves a page deleted at ts out of the trash and back to its title
+func (s *FileStore) Restore(ctx context.Context, title, ts string) error {
+	if err := validateTitle(title); err != nil {
+		return err
+	}
+	if _, err := parseTimestamp(ts); err != nil {
+		return err
+	}
+	l := s.lock(title)
+	l.Lock()
+	defer l.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	src := s.trashPath(title, ts)
+	ext := ".txt"
+	if _, err := os.Stat(src + ext + gzSuffix); err == nil {
+		ext += gzSuffix
+	}
+	if _, err := os.Stat(src + ext); err != nil {
+		return err
+	}
+	if _, err := s.find(title); err == nil {
+		return errPageExists
+	}
+	dst := strings.TrimSuffix(s.path(title), ".txt") + ext
+	if err := os.MkdirAll(filepath.Dir(dst), s.DirMode); err != nil {
+		return err
+	}
+	if err := os.Rename(src+ext, dst); err != nil {
+		return err
+	}
+	slog.Debug("moved page out of the trash", "from", src+ext, "to", dst)
+	if err := os.Rename(src+".meta", s.metaPath(title)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// trashHandler lists the pages in the trash on /trash, each with a button to restore it
+func (s *wikiServer) trashHandler(w http.ResponseWriter, r *http.Request) {
+	ts, ok := s.store.(TrashStore)
+	var pages []TrashedPage
+	if ok {
+		var err error
+		pages, err = ts.ListTrash(r.Context())
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	renderTemplate(w, r, "trash", struct {
+		Enabled   bool
+		Pages     []TrashedPage
+		CSRFToken string
+	}{ok && !*hardDelete, pages, csrfToken(w, r)})
+}
+
+// restoreHandler brings a page back out of the trash on POST /restore/<title>, with the ts form field
+// saying which deleted copy if the title was deleted more than once
+func (s *wikiServer) restoreHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validCSRF(r) {
+		http.Error(w, "invalid or missing CSRF token", http.StatusForbidden)
+		return
+	}
+	if err := s.checkPageLimit(r.Context(), title); errors.Is(err, errTooManyPages) {
+		http.Error(w, errTooManyPages.Error(), http.StatusInsufficientStorage)
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	err := s.restorePage(r.Context(), title, r.FormValue("ts"))
+	if errors.Is(err, os.ErrNotExist) {
+		notFound(w, r)
+		return
+	}
+	if errors.Is(err, errPageExists) {
+		http.Error(w, "a page called "+title+" exists again, rename or delete it before restoring this one", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	http.Redirect(w, r, pathTo("/view/"+title), http.StatusFound)
+}
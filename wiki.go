@@ -1,109 +1,1104 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"html/template"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/parser"
+)
+
+// Command line flags, parsed in main
+// addr is the address the server listens on and dataDir is where pages are stored on disk
+// storeKind picks the backend pages are kept in, and dbPath is the database used by the sqlite one
+// maxSize caps how many bytes a save request can send, so one request can't fill the disk or memory,
+// and maxTitle caps how long a title can be. maxPages caps how many pages there can be, 0 for no limit. ignoreCase lets a title in the URL match a page differing only in case
+// templateDir is a directory of themes to read the templates from instead of the ones built into the binary,
+// and dev re-reads them and the static files from disk on every request so they can be worked on without restarting
+var (
+	addr        = flag.String("addr", ":8080", "address to listen on")
+	dataDir     = flag.String("datadir", "data", "directory pages are stored in")
+	storeKind   = flag.String("store", "file", "where pages are kept: file, sqlite or memory")
+	dbPath      = flag.String("db", "wiki.db", "SQLite database file used when -store is sqlite")
+	maxSize     = flag.Int64("maxsize", 1<<20, "maximum size in bytes of a save request")
+	maxTitle    = flag.Int("maxtitle", 100, "maximum length of a page title")
+	maxPages    = flag.Int("maxpages", 0, "maximum number of pages, 0 for no limit")
+	ignoreCase  = flag.Bool("ignorecase", false, "let titles in URLs match pages differing only in case, at the cost of listing every page on a miss")
+	templateDir = flag.String("tmpldir", "", "directory of theme directories the HTML templates are read from instead of the ones built in")
+	dev         = flag.Bool("dev", false, "read templates and static files from disk and re-parse templates on every request")
 )
 
 // A Page represents a wiki page with a title and body.
 // The body element is a byte slice instead of a string as this is type
 // expeceted by the io libraries we're using
+// ModTime is when the page was last saved and Created is when it was first saved, as reported by the store it was loaded from
+// Author, Tags, Updated and Format come from the optional front matter at the top of the body
+// HTML is the rendered body, and is only filled in when the page is viewed, as are TOC, Words, Chars, ReadTime, Attachments and Backlinks
+// CSRFToken is put into the page's forms so the POSTs they make are accepted
+// Error is shown above the edit form when a save is turned away
+// Version is the version of the page the edit form was opened on, see version
+// ReadOnly hides the links and forms for changing the page when the wiki is running with -readonly
+// DraftTime is when an autosaved draft newer than the page was saved, so the edit form can offer it back, see addDraft
 type Page struct {
-	Title string
-	Body  []byte
+	Title       string
+	Body        []byte
+	ModTime     time.Time
+	Created     time.Time
+	Author      string
+	Tags        []string
+	Updated     time.Time
+	Format      string
+	HTML        template.HTML
+	TOC         []TOCEntry
+	Words       int
+	Chars       int
+	ReadTime    string
+	Attachments []string
+	Backlinks   []string
+	CSRFToken   string
+	Error       string
+	Version     string
+	ReadOnly    bool
+	DraftTime   time.Time
+	// RedirectedFrom is the page that redirected the reader here, see followRedirect
+	RedirectedFrom string
+
+	// content is the body without its front matter, see Content
+	content []byte
 }
 
+// titlePattern is what a page title is allowed to look like
+// Titles are one or more alphanumeric segments separated by slashes, like Projects/Alpha.
+// Every regexp that matches a title is built from it so they all agree
+const titlePattern = "[a-zA-Z0-9]+(?:/[a-zA-Z0-9]+)*"
+
+// reservedDirs are directories inside the data directory that hold things other than pages
+// No title may start with one of them, otherwise its pages would be mixed up with that data
+var reservedDirs = []string{"history", "attachments", "drafts"}
+
 // Will panic if the regex fails to compile
-var validPath = regexp.MustCompile("^/(edit|save|view)/([a-zA-Z0-9]+)$")
+var validPath = regexp.MustCompile("^/(edit|save|view|delete|history|diff|rename|upload|restore|draft)/(" + titlePattern + ")$")
+
+// validTitle matches a whole string against titlePattern, for titles that come from somewhere other than the URL path
+var validTitle = regexp.MustCompile("^" + titlePattern + "$")
+
+// templateNames lists every template a theme in tmpl/ has to have, without the .html extension
+var templateNames = []string{"edit", "view", "index", "history", "revision", "diff", "search", "404", "tags", "tag", "recent", "import", "print", "conflict", "trash", "popular", "brokenlinks"}
+
+// parseTemplates reads and parses every template into a single *Template for the given language
+// They come from templateFS, which is the copy built into the binary unless -dev or -tmpldir say otherwise.
+// The templates can call the helpers in templateFuncs, including {{t "key"}} for UI strings in lang
+func parseTemplates(lang string) (*template.Template, error) {
+	files := make([]string, len(templateNames))
+	for i, name := range templateNames {
+		files[i] = name + ".html"
+	}
+	return template.New("").Funcs(templateFuncs(lang)).ParseFS(templateFS(), files...)
+}
+
+// cache all our templates on startup, allowing all our templates to exist in a simple *Template per language
+// They're parsed in main, which exits if they can't be loaded as we shouldn't even run the server without them
+// With -dev the cached templates are ignored and parsed again on every render instead
+var templates map[string]*template.Template
+
+// checkTemplateDir makes sure the template directory is there when templates are read from disk,
+// with a hint about the likely cause if it isn't
+func checkTemplateDir() error {
+	dir := diskTemplateDir()
+	if dir == "" {
+		return nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("template directory %q not found, run the server from the directory containing it or set -tmpldir: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("template directory %q is not a directory", dir)
+	}
+	return nil
+}
+
+// errInvalidTitle is returned by the storage functions when given a title that could escape the data directory
+var errInvalidTitle = errors.New("invalid page title")
+
+// errTitleTooLong is returned by validateTitle for a title longer than -maxtitle
+var errTitleTooLong = errors.New("page title is too long")
+
+// errPageExists is returned by renamePage when a page already has the new title
+var errPageExists = errors.New("page already exists")
+
+// errTooManyPages is returned by checkPageLimit when a new page would go over -maxpages
+var errTooManyPages = errors.New("the wiki has reached its maximum number of pages")
+
+// checkPageLimit makes sure there's room for a page to be saved under title
+// Saving a page that's already there doesn't add one, so it's always allowed.
+// Two new pages saved at the same moment can both get the last place, so the limit can be overshot slightly
+func (s *wikiServer) checkPageLimit(ctx context.Context, title string) error {
+	if *maxPages <= 0 || s.pageExists(ctx, title) {
+		return nil
+	}
+	titles, err := s.listPages(ctx)
+	if err != nil {
+		return err
+	}
+	if len(titles) >= *maxPages {
+		return errTooManyPages
+	}
+	return nil
+}
+
+// validateTitle checks a title is safe to use as part of a filename
+// validPath already restricts titles coming in over HTTP, but this makes sure
+// loadPage and save can't be used to read or write outside the data directory
+// even if they are called with a title that didn't come through makeHandler.
+// As every segment has to be alphanumeric, empty, . and .. segments are all rejected.
+// Titles are also kept to -maxtitle bytes, so the filenames made from them stay within what filesystems allow
+func validateTitle(title string) error {
+	if len(title) > *maxTitle {
+		return errTitleTooLong
+	}
+	if !validTitle.MatchString(title) {
+		return errInvalidTitle
+	}
+	first, _, _ := strings.Cut(title, "/")
+	if slices.Contains(reservedDirs, first) {
+		return errInvalidTitle
+	}
+	return nil
+}
+
+// Stats counts the words and characters in the page's content, leaving out any front matter
+// Characters are counted as runes rather than bytes so text that isn't ASCII is counted correctly
+func (p *Page) Stats() (words, chars int) {
+	content := p.Content()
+	return len(bytes.Fields(content)), utf8.RuneCount(content)
+}
 
-// cache all our templates on first run, allowing all our templates to exist in a simple *Template
-// template.Must will panic when a non-nil error value is passed to it
-// Panicing is appropiate as if we can't load any templates, we shouldn't even run the server
-var templates = template.Must(template.ParseFiles("tmpl/edit.html", "tmpl/view.html"))
+// wordsPerMinute is how fast a reader is taken to read for ReadingTime
+const wordsPerMinute = 200
 
-// This function allows us to save our pages to disk, allowing for persistence storage
-// This is a method named save that takes as its reciever p, a pointer to Page.
-// Takes no parameters and returns an error type
-func (p *Page) save() error {
-	filename := p.Title + ".txt"
-	return os.WriteFile("data/"+filename, p.Body, 0600)
+// ReadingTime estimates how long the page's content takes to read, in whole minutes
+// Anything with words in it takes at least a minute, and an empty page takes no time at all
+func (p *Page) ReadingTime() time.Duration {
+	words, _ := p.Stats()
+	if words == 0 {
+		return 0
+	}
+	minutes := (words + wordsPerMinute - 1) / wordsPerMinute
+	return time.Duration(minutes) * time.Minute
+}
+
+// readingTimeText formats a ReadingTime for the view page, or "" for a page with nothing to read
+func readingTimeText(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return fmt.Sprintf("~%d min read", int(d/time.Minute))
+}
+
+// A wikiServer is the wiki's handlers along with the store they keep pages in
+// The handlers never get at pages any other way, so they don't care which backend is in use
+// and can be tested against a MemStore. tags and links are the indexes built from the store's pages
+type wikiServer struct {
+	store Store
+	tags  pageIndex
+	links pageIndex
+	// saves limits how often each client can save, through the edit form and the API alike
+	saves *rateLimiter
+}
+
+// newWikiServer returns the handlers for the wiki kept in store
+func newWikiServer(store Store) *wikiServer {
+	s := &wikiServer{store: store, saves: saveLimiter()}
+	s.tags.build = s.buildTagIndex
+	s.links.build = s.buildBacklinkIndex
+	return s
+}
+
+// The methods below are how the handlers get at pages
+// Anything that changes a page also throws away the cached indexes built from the pages
+
+// savePage saves the page to the store, allowing for persistence storage
+func (s *wikiServer) savePage(ctx context.Context, p *Page) error {
+	defer s.invalidateIndexes()
+	if err := s.store.Save(ctx, p); err != nil {
+		return err
+	}
+	events.publish(p.Title)
+	return nil
 }
 
-// This function loadPage constructs our filename
-// Reads that file from disk and returns a pointer to a Page struct
-func loadPage(title string) (*Page, error) {
-	filename := "data/" + title + ".txt"
-	body, err := os.ReadFile(filename)
+// This function loadPage fetches the page with the given title from the store and returns a pointer to it
+// Any front matter at the top of the body is parsed into the page's metadata
+// A page that doesn't exist gives an error satisfying errors.Is(err, os.ErrNotExist), anything else is a real failure
+// With -ignorecase a title that doesn't exist falls back to one differing only in case, see matchTitleFold.
+// The page returned then has the title it was actually found under
+func (s *wikiServer) loadPage(ctx context.Context, title string) (*Page, error) {
+	p, err := s.store.Load(ctx, title)
+	if errors.Is(err, os.ErrNotExist) && *ignoreCase {
+		if match, ok := s.matchTitleFold(ctx, title); ok {
+			p, err = s.store.Load(ctx, match)
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &Page{Title: title, Body: body}, nil
+	p.parseFrontMatter()
+	return p, nil
 }
 
-// This renderTemplate function allows us to more easily write and execute our HTML files
-func renderTemplate(w http.ResponseWriter, tmpl string, p *Page) {
-	err := templates.ExecuteTemplate(w, tmpl+".html", p)
+// matchTitleFold looks for a page whose title is the same as title apart from case
+// It has to list every page, which is why it's only done with -ignorecase. An exact match never
+// gets here, as loadPage tries that first. If several pages differ from title only in case,
+// the one that sorts first byte by byte wins, so HomePage is picked over Homepage
+func (s *wikiServer) matchTitleFold(ctx context.Context, title string) (string, bool) {
+	titles, err := s.listPages(ctx)
+	if err != nil {
+		return "", false
+	}
+	slices.Sort(titles)
+	for _, t := range titles {
+		if strings.EqualFold(t, title) {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+// pageExists reports whether a page with the given title has been saved
+// It's called for every wiki link on a page, so stores that can answer without loading the page are asked directly
+func (s *wikiServer) pageExists(ctx context.Context, title string) bool {
+	if e, ok := s.store.(Exister); ok {
+		return e.Exists(title)
+	}
+	_, err := s.store.Load(ctx, title)
+	return err == nil
+}
+
+// deletePage removes the page with the given title
+// If the page doesn't exist the returned error satisfies errors.Is(err, os.ErrNotExist)
+func (s *wikiServer) deletePage(ctx context.Context, title string) error {
+	defer s.invalidateIndexes()
+	return s.store.Delete(ctx, title)
+}
+
+// restorePage brings back a page deleted at ts, for stores with a trash
+func (s *wikiServer) restorePage(ctx context.Context, title, ts string) error {
+	t, ok := s.store.(TrashStore)
+	if !ok {
+		return os.ErrNotExist
+	}
+	defer s.invalidateIndexes()
+	return t.Restore(ctx, title, ts)
+}
+
+// listPages returns the title of every page in the wiki
+func (s *wikiServer) listPages(ctx context.Context) ([]string, error) {
+	return s.store.List(ctx)
+}
+
+// renamePage moves a page, along with its attachments, to a new title
+// It refuses to overwrite a page that already has the new title and returns errPageExists instead.
+// Stores that can't rename a page themselves get it copied to the new title and then deleted
+func (s *wikiServer) renamePage(ctx context.Context, oldTitle, newTitle string) error {
+	defer s.invalidateIndexes()
+	if err := s.renameInStore(ctx, oldTitle, newTitle); err != nil {
+		return err
+	}
+	return moveAttachments(oldTitle, newTitle)
+}
+
+// renameInStore moves the page itself, without its attachments
+func (s *wikiServer) renameInStore(ctx context.Context, oldTitle, newTitle string) error {
+	if r, ok := s.store.(Renamer); ok {
+		return r.Rename(ctx, oldTitle, newTitle)
+	}
+	p, err := s.store.Load(ctx, oldTitle)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
 	}
+	if oldTitle == newTitle || s.pageExists(ctx, newTitle) {
+		return errPageExists
+	}
+	if err := s.store.Save(ctx, &Page{Title: newTitle, Body: p.Body, Created: p.Created}); err != nil {
+		return err
+	}
+	return s.store.Delete(ctx, oldTitle)
+}
+
+// moveAttachments moves a renamed page's attachments over to its new title
+func moveAttachments(oldTitle, newTitle string) error {
+	return moveFiles(attachmentDir(oldTitle), attachmentDir(newTitle), 0700)
+}
+
+// markdown is the Markdown converter pages are rendered with, with syntax highlighting for code blocks
+// and [PageName] links. Headings are given ids so the table of contents can link to them
+var markdown = goldmark.New(
+	goldmark.WithExtensions(highlighter, wikiLinks),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+)
+
+// renderMarkdown converts a Markdown page body into HTML
+// exists is asked about every [PageName] link so the ones to missing pages can be marked.
+// goldmark leaves out raw HTML in the source by default, so the output is safe to trust
+func renderMarkdown(body []byte, exists func(title string) bool) ([]byte, error) {
+	pc := parser.NewContext()
+	pc.Set(pageExistsKey, exists)
+	var buf bytes.Buffer
+	if err := markdown.Convert(body, &buf, parser.WithContext(pc)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sanitizer decides which HTML is allowed through to the browser when a page is viewed
+// It starts from bluemonday's policy for user generated content, which keeps the usual formatting,
+// links, images, tables and so on but drops scripts, styles and event handler attributes.
+// Code blocks also keep their class names, which the syntax highlighting is done with,
+// and links can have the missing class wiki links to pages that don't exist are given.
+// Only links off the wiki get rel=nofollow, so search engines still follow the links between pages
+var sanitizer = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.RequireNoFollowOnLinks(false)
+	p.RequireNoFollowOnFullyQualifiedLinks(true)
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^[a-zA-Z0-9 _-]+$`)).OnElements("pre", "code", "span")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^missing$`)).OnElements("a")
+	return p
+}()
+
+// renderBody runs a page's content through everything needed to display it
+// Markdown is converted to HTML first, while a page in formatHTML is taken as the HTML already.
+// Either way wiki links are added first and then the whole thing is cleaned up by the sanitizer.
+// goldmark already leaves out raw HTML, so for Markdown the sanitizer is there in case anything gets past it,
+// but for HTML pages it's what keeps scripts and the like out.
+// The result is wrapped in template.HTML so html/template doesn't escape it a second time
+func (s *wikiServer) renderBody(ctx context.Context, p *Page) (template.HTML, error) {
+	exists := func(title string) bool { return s.pageExists(ctx, title) }
+	var html []byte
+	if p.Format == formatHTML {
+		html = linkifyHTML(p.Content(), exists)
+	} else {
+		var err error
+		html, err = renderMarkdown(p.Content(), exists)
+		if err != nil {
+			return "", err
+		}
+	}
+	return template.HTML(sanitizer.SanitizeBytes(html)), nil
+}
+
+// This renderTemplate function allows us to more easily write and execute our HTML files
+// data is whatever the template expects, usually a *Page
+// In -dev mode the templates are read from disk again first, so changes to them show up straight away
+func renderTemplate(w http.ResponseWriter, r *http.Request, tmpl string, data any) {
+	renderTemplateStatus(w, r, http.StatusOK, tmpl, data)
+}
+
+// renderTemplateStatus is renderTemplate for responses that aren't a 200
+// The template is executed into a buffer first, so if it fails the client gets a clean 500
+// rather than half a page, and the status code can still be set.
+// The page is put inside an <html> element with the class of the reader's theme, which the stylesheet picks colours by
+func renderTemplateStatus(w http.ResponseWriter, r *http.Request, status int, tmpl string, data any) {
+	l := lang(r)
+	t := templates[l]
+	if *dev {
+		// An edit that breaks a template should show up as an error, not take the server down
+		var err error
+		t, err = parseTemplates(l)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, tmpl+".html", data); err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	varyByReader(w.Header())
+	w.Header().Set("Content-Language", l)
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<html class=%q lang=%q>\n", theme(r), l)
+	buf.WriteTo(w)
+	io.WriteString(w, "</html>\n")
+}
+
+// varyByReader sets Vary for a page made from the templates
+// It differs depending on the theme and language cookies and the language asked for, so caches have to keep a copy for each
+func varyByReader(h http.Header) {
+	addVary(h, "Cookie")
+	addVary(h, "Accept-Language")
+}
+
+// loadTemplates picks the theme, reads the locales and parses the templates for every language, for renderTemplate
+func loadTemplates() error {
+	if err := checkTemplateDir(); err != nil {
+		return err
+	}
+	if err := chooseTheme(); err != nil {
+		return err
+	}
+	if err := loadLocales(); err != nil {
+		return err
+	}
+	templates = make(map[string]*template.Template)
+	for _, l := range languages() {
+		t, err := parseTemplates(l)
+		if err != nil {
+			return fmt.Errorf("parsing templates: %w", err)
+		}
+		templates[l] = t
+	}
+	return nil
+}
+
+// notFound sends our own 404 page in place of http.NotFound's plain text
+func notFound(w http.ResponseWriter, r *http.Request) {
+	renderTemplateStatus(w, r, http.StatusNotFound, "404", r.URL.Path)
 }
 
 // function literal and closure that extracts the title from the URL and validates the path before passing it to a handler
+// A single trailing slash, like /view/Home/, is redirected to the path without it so every page has one address.
+// GET and HEAD get a 301, anything else a 308 so a form posted to the wrong path keeps its method and body
 func makeHandler(fn func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		m := validPath.FindStringSubmatch(r.URL.Path)
+		if trimmed, ok := strings.CutSuffix(r.URL.Path, "/"); m == nil && ok && validPath.MatchString(trimmed) {
+			u := *r.URL
+			u.Path = trimmed
+			status := http.StatusPermanentRedirect
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				status = http.StatusMovedPermanently
+			}
+			http.Redirect(w, r, pathTo(u.RequestURI()), status)
+			return
+		}
 		if m == nil {
-			http.NotFound(w, r)
+			notFound(w, r)
+			return
+		}
+		if err := validateTitle(m[2]); err != nil {
+			if errors.Is(err, errTitleTooLong) {
+				http.Error(w, titleTooLongMessage(), http.StatusBadRequest)
+				return
+			}
+			notFound(w, r)
 			return
 		}
 		fn(w, r, m[2])
 	}
 }
 
+// homePage is the page "/" sends readers to. Set to "" the root shows the index instead, which is always on /pages as well
+var homePage = flag.String("home", "FrontPage", `page "/" redirects to, or "" to show the list of pages there`)
+
+// rootHandler handles "/", sending the reader to the home page
+// A home page that hasn't been written yet goes to its edit form, just like viewHandler would.
+// "/" matches every path that no other handler has claimed, so anything other than the root itself gets the 404 page
+func (s *wikiServer) rootHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		notFound(w, r)
+		return
+	}
+	if *homePage == "" {
+		s.indexHandler(w, r)
+		return
+	}
+	// Left to viewHandler on a read only wiki, which has no edit form and so gives the 404 instead
+	if !*readOnly && !s.pageExists(r.Context(), *homePage) {
+		http.Redirect(w, r, pathTo("/edit/"+*homePage), http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, pathTo("/view/"+*homePage), http.StatusFound)
+}
+
+// The index page on /pages lists every page in the wiki with a link to view it
+// Pages are listed in alphabetical order, split into pages of ?per= titles with ?page= picking which one.
+// Numbers out of range are clamped rather than rejected, so a stale link still shows something
+func (s *wikiServer) indexHandler(w http.ResponseWriter, r *http.Request) {
+	titles, err := s.listPages(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	slices.Sort(titles)
+	per := clamp(queryInt(r, "per", defaultPerPage), 1, maxPerPage)
+	pages := max(1, (len(titles)+per-1)/per)
+	page := clamp(queryInt(r, "page", 1), 1, pages)
+	start := (page - 1) * per
+	end := min(start+per, len(titles))
+	renderTemplate(w, r, "index", Listing{
+		Titles: titles[start:end],
+		Total:  len(titles),
+		Page:   page,
+		Pages:  pages,
+		Per:    per,
+		Notice: notices[r.URL.Query().Get("notice")],
+	})
+}
+
+// notices are the messages other handlers can have shown at the top of the index by redirecting to /pages?notice=<key>
+// Only these can be shown, so a link can't be made to put any text it likes on the wiki
+var notices = map[string]string{
+	"nopages": "There are no pages yet, so there's no random page to show.",
+}
+
+// defaultPerPage and maxPerPage are how many titles the index shows at once by default and at most
+const (
+	defaultPerPage = 50
+	maxPerPage     = 1000
+)
+
+// A Listing is one page of the index
+// Page counts from 1, and Pages is how many there are in total. Notice is a message to show above the list
+type Listing struct {
+	Titles []string
+	Total  int
+	Page   int
+	Pages  int
+	Per    int
+	Notice string
+}
+
+// Prev and Next return the numbers of the pages either side of this one, or 0 if there isn't one
+func (l Listing) Prev() int {
+	if l.Page > 1 {
+		return l.Page - 1
+	}
+	return 0
+}
+
+func (l Listing) Next() int {
+	if l.Page < l.Pages {
+		return l.Page + 1
+	}
+	return 0
+}
+
+// queryInt returns the query parameter name as a number, or def if it's missing or not a number
+func queryInt(r *http.Request, name string, def int) int {
+	n, err := strconv.Atoi(r.URL.Query().Get(name))
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// clamp returns n moved into the range lo to hi
+func clamp(n, lo, hi int) int {
+	return max(lo, min(n, hi))
+}
+
 // A function to actually server our pages to the browser
 // The title of the page is extracted from the URL, minus the "/view/" prefix
-func viewHandler(w http.ResponseWriter, r *http.Request, title string) {
-	p, err := loadPage(title)
+// The body is rendered from Markdown here rather than on save, so the stored page is always the raw source
+// A client that already has the current version gets a 304 instead of the whole page being rendered again
+// Only a page that doesn't exist goes to the edit form, any other error loading it is a 500
+// A HEAD request is just a 200 or 404 for whether the page exists.
+// With ?print=1 the page is rendered without any of the links and forms around it, for printing
+func (s *wikiServer) viewHandler(w http.ResponseWriter, r *http.Request, title string) {
+	p, err := s.loadPage(r.Context(), title)
+	if s.followRedirect(w, r, title, p, err) {
+		return
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		// HEAD is used to check whether a page exists, so it's told it doesn't rather than sent to the edit form.
+		// There's no edit form to send anyone to on a read only wiki either
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if *readOnly {
+			notFound(w, r)
+			return
+		}
+		http.Redirect(w, r, pathTo("/edit/"+title), http.StatusFound)
+		return
+	}
 	if err != nil {
-		http.Redirect(w, r, "/edit/"+title, http.StatusFound)
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	// Found under a title in different case, send the reader to the page's real address
+	if p.Title != title {
+		http.Redirect(w, r, pathTo("/view/"+p.Title), http.StatusMovedPermanently)
+		return
+	}
+	// The page is found and redirected to the same way for HEAD as for GET, but there's no need to render it
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		return
 	}
-	renderTemplate(w, "view", p)
+	views.count(title)
+	w.Header().Set(contentHashHeader, contentHash(p.Body))
+	// The same URL gives HTML or the page's source depending on Accept, so caches have to keep them apart
+	addVary(w.Header(), "Accept")
+	// Tools that want the source can ask for it with Accept, or with ?raw=1 from a browser.
+	// It's the body exactly as stored, front matter and all
+	format := negotiate(r.Header.Get("Accept"), "text/html", "text/markdown", "text/plain")
+	if r.URL.Query().Get("raw") == "1" && format == "text/html" {
+		format = "text/plain"
+	}
+	if format != "text/html" {
+		// The bytes are the same either way, but a strong ETag belongs to one representation, Content-Type and all
+		if notModified(w, r, etagWithSuffix(pageETag(p.Body), sourceETagSuffixes[format]), p.ModTime) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", format+"; charset=utf-8")
+		w.Write(p.Body)
+		return
+	}
+	// Everything the ETag is made from has to be worked out before the 304 can be sent
+	p.Attachments, err = listAttachments(title)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	p.Backlinks, err = s.backlinks(r.Context(), title)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	p.CSRFToken = csrfToken(w, r)
+	p.ReadOnly = *readOnly
+	exists := func(title string) bool { return s.pageExists(r.Context(), title) }
+	// A 304 has to say what it varies by the same as the page it stands in for
+	varyByReader(w.Header())
+	if notModified(w, r, viewETag(r, p, exists), s.viewModTime(r.Context(), p)) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	p.HTML, err = s.renderBody(r.Context(), p)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	// The print view is just the title and body, so none of the rest needs working out
+	if r.URL.Query().Get("print") != "" {
+		renderTemplate(w, r, "print", p)
+		return
+	}
+	// The table of contents is worked out from Markdown headings, hand written HTML is left to make its own
+	if p.Format != formatHTML {
+		p.TOC = buildTOC(p.Content())
+	}
+	p.Words, p.Chars = p.Stats()
+	p.ReadTime = readingTimeText(p.ReadingTime())
+	if from := r.URL.Query().Get("from"); validateTitle(from) == nil {
+		p.RedirectedFrom = from
+	}
+	renderTemplate(w, r, "view", p)
+}
+
+// sourceETagSuffixes tell apart the ETags of the formats the view sends a page's source in
+var sourceETagSuffixes = map[string]string{
+	"text/markdown": "-md",
+	"text/plain":    "-txt",
+}
+
+// titleTooLongMessage is what the client is told when a title is longer than -maxtitle
+func titleTooLongMessage() string {
+	return fmt.Sprintf("page titles can be at most %d characters long", *maxTitle)
 }
 
 // This function handles our /edit/* path
 // It returns a form that allows the user to
 // edit the body of a function and then submit it to our save handler.
-func editHandler(w http.ResponseWriter, r *http.Request, title string) {
-	p, err := loadPage(title)
+// A page that doesn't exist yet gets an empty form
+func (s *wikiServer) editHandler(w http.ResponseWriter, r *http.Request, title string) {
+	p, err := s.loadPage(r.Context(), title)
+	if errors.Is(err, os.ErrNotExist) {
+		// A new page, so there's no saved version yet, and it starts from a page template if there is one
+		p, err = &Page{Title: title, Body: newPageBody(r)}, nil
+	} else if err == nil {
+		p.Version = p.version()
+	}
+	// Editing under the wrong case would save a second page rather than change this one
+	if err == nil && p.Title != title {
+		http.Redirect(w, r, pathTo("/edit/"+p.Title), http.StatusFound)
+		return
+	}
+	// Showing an empty form for a page that's there but couldn't be read would have it overwritten on save
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	addDraft(r, p)
+	// The body may have come from a page template or draft, so the format picker has to go by what's in it now
+	p.parseFrontMatter()
+	p.CSRFToken = csrfToken(w, r)
+	renderTemplate(w, r, "edit", p)
+}
+
+// previewPage shows the edit form again with the submitted body rendered above it
+// It goes through the same pipeline as viewHandler, but nothing is written to disk
+func (s *wikiServer) previewPage(w http.ResponseWriter, r *http.Request, p *Page) {
+	var err error
+	p.HTML, err = s.renderBody(r.Context(), p)
 	if err != nil {
-		p = &Page{Title: title}
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
 	}
-	renderTemplate(w, "edit", p)
+	p.CSRFToken = csrfToken(w, r)
+	renderTemplate(w, r, "edit", p)
 }
 
 // When the save button is hit on edit, it sends its form data to this handler
 // This handler then extracts the body from the form and recreates the page
 // It is then saved and redirected to the view page
+// If the preview button was hit instead, the page is rendered back into the edit form without being saved
+// An empty body isn't saved either, the edit form comes back with an error saying why,
+// and a save of a page someone else has changed in the meantime gets a 409 and the conflict page
 // /save is used more as an API endpoint than a page
-func saveHandler(w http.ResponseWriter, r *http.Request, title string) {
+// The request body is capped at -maxsize before the form is parsed, so an oversized save is rejected
+// with a 413 without ever being read into memory
+func (s *wikiServer) saveHandler(w http.ResponseWriter, r *http.Request, title string) {
+	r.Body = http.MaxBytesReader(w, r.Body, *maxSize)
+	if err := r.ParseForm(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "page is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validCSRF(r) {
+		http.Error(w, "invalid or missing CSRF token", http.StatusForbidden)
+		return
+	}
 	body := r.FormValue("body")
-	p := &Page{Title: title, Body: []byte(body)}
-	err := p.save()
+	// The edit form's format picker wins over whatever the front matter in the body says
+	if r.Form.Has("format") {
+		format := r.FormValue("format")
+		if !validFormat(format) {
+			http.Error(w, "unknown format "+strconv.Quote(format), http.StatusBadRequest)
+			return
+		}
+		body = string(setFormat([]byte(body), format))
+	}
+	p := &Page{Title: title, Body: []byte(body), Version: r.FormValue("version")}
+	if r.FormValue("preview") != "" {
+		s.previewPage(w, r, p)
+		return
+	}
+	// An empty save is much more likely to be a mistake than a page meant to be blank,
+	// and a page can always be deleted if that's what was wanted
+	if strings.TrimSpace(body) == "" {
+		p.Error = "A page can't be saved with nothing in it. Delete the page instead if you want it gone."
+		p.CSRFToken = csrfToken(w, r)
+		renderTemplateStatus(w, r, http.StatusUnprocessableEntity, "edit", p)
+		return
+	}
+	// The edit form sends the version of the page it was opened on. If the page has been
+	// saved since then, saving would throw away the other change, so ask the user to merge instead.
+	// A save without a version, e.g. from a script, always goes through
+	if r.Form.Has("version") {
+		current, err := s.loadPage(r.Context(), title)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if current.version() != r.FormValue("version") {
+			s.conflictPage(w, r, p, current)
+			return
+		}
+	}
+	if err := s.checkPageLimit(r.Context(), title); errors.Is(err, errTooManyPages) {
+		http.Error(w, errTooManyPages.Error(), http.StatusInsufficientStorage)
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	// Only a save that's going to be written counts towards -rate, so previews and rejected saves are free
+	if retryAfter, ok := s.saves.allow(r); !ok {
+		w.Header().Set("Retry-After", retryAfter)
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	err := s.savePage(r.Context(), p)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, err)
 		return
 	}
-	http.Redirect(w, r, "/view/"+title, http.StatusFound)
+	// The page has what the draft was being kept for now, but failing to clear it doesn't undo the save
+	if err := clearDraft(title); err != nil {
+		slog.Error("clearing draft", "title", title, "err", err)
+	}
+	http.Redirect(w, r, pathTo("/view/"+title), http.StatusFound)
+}
+
+// version identifies the saved version of a page for the edit form, so a save can tell whether it has changed since
+// A page that doesn't exist, including a nil one, has the empty version
+func (p *Page) version() string {
+	if p == nil {
+		return ""
+	}
+	return pageETag(p.Body)
+}
+
+// conflictPage is sent instead of saving when the page changed after the edit form was opened
+// It shows what the page is now next to the user's text, in a form carrying the new version,
+// so saving again keeps their text on purpose. current is nil if the page has been deleted since
+func (s *wikiServer) conflictPage(w http.ResponseWriter, r *http.Request, p, current *Page) {
+	data := struct {
+		*Page
+		Current *Page
+	}{p, current}
+	if current != nil {
+		var err error
+		current.HTML, err = s.renderBody(r.Context(), current)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	p.Version = current.version()
+	p.CSRFToken = csrfToken(w, r)
+	renderTemplateStatus(w, r, http.StatusConflict, "conflict", data)
+}
+
+// deleteHandler removes a page and then sends the user back to the index
+// Only POST is accepted so following a link or refreshing can't delete a page by accident
+func (s *wikiServer) deleteHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validCSRF(r) {
+		http.Error(w, "invalid or missing CSRF token", http.StatusForbidden)
+		return
+	}
+	err := s.deletePage(r.Context(), title)
+	if errors.Is(err, os.ErrNotExist) {
+		notFound(w, r)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	http.Redirect(w, r, pathTo("/pages"), http.StatusFound)
+}
+
+// renameHandler moves a page to the title given in the newtitle form value and then shows it under its new name
+// It is a 404 if the page doesn't exist and a 409 if the new title is already taken
+func (s *wikiServer) renameHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validCSRF(r) {
+		http.Error(w, "invalid or missing CSRF token", http.StatusForbidden)
+		return
+	}
+	newTitle := r.FormValue("newtitle")
+	if err := validateTitle(newTitle); err != nil {
+		if errors.Is(err, errTitleTooLong) {
+			http.Error(w, titleTooLongMessage(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "new title may only contain letters and numbers", http.StatusBadRequest)
+		return
+	}
+	err := s.renamePage(r.Context(), title, newTitle)
+	if errors.Is(err, os.ErrNotExist) {
+		notFound(w, r)
+		return
+	}
+	if errors.Is(err, errPageExists) {
+		http.Error(w, "a page called "+newTitle+" already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	http.Redirect(w, r, pathTo("/view/"+newTitle), http.StatusFound)
 }
 
-// Handles our http requests and then listens and serves on port 8080
+// Handles our http requests and then listens and serves on the address given by -addr
+// On SIGINT or SIGTERM the server stops accepting connections and waits for
+// in-flight requests, such as a save that is halfway through, before exiting
 func main() {
-	http.HandleFunc("/view/", makeHandler(viewHandler))
-	http.HandleFunc("/edit/", makeHandler(editHandler))
-	http.HandleFunc("/save/", makeHandler(saveHandler))
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	flag.Parse()
+	if err := setupLogging(); err != nil {
+		log.Fatal(err)
+	}
+	if *useTLS {
+		if err := checkTLS(); err != nil {
+			fatal(err.Error())
+		}
+	}
+	if *useTLS && *useH2C {
+		fatal("-h2c is HTTP/2 without TLS, with -tls HTTP/2 is already on")
+	}
+	if err := loadCredentials(); err != nil {
+		fatal(err.Error())
+	}
+	if err := loadFavicon(); err != nil {
+		fatal(err.Error())
+	}
+	if *basePath != "" && !validBasePath.MatchString(*basePath) {
+		fatal("invalid -basepath, it should look like /wiki", "basepath", *basePath)
+	}
+	if *purgeAfter < 0 || (*purgeAfter > 0 && *purgeInterval <= 0) {
+		fatal("-purge-after can't be negative, and needs a positive -purge-interval")
+	}
+	if *homePage != "" {
+		if err := validateTitle(*homePage); err != nil {
+			fatal("invalid -home", "home", *homePage, "err", err)
+		}
+	}
+	if err := loadTemplates(); err != nil {
+		fatal(err.Error())
+	}
+	st, err := openStore(*storeKind)
+	if err != nil {
+		fatal(err.Error())
+	}
+	s := newWikiServer(st)
+
+	if err := views.load(); err != nil {
+		fatal("loading view counts", "err", err)
+	}
+	stopViews := make(chan struct{})
+	go views.saveEvery(viewsSaveInterval, stopViews)
+
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	purgeDone := make(chan struct{})
+	if *purgeAfter > 0 {
+		slog.Info("purging idle pages", "after", *purgeAfter, "interval", *purgeInterval)
+		go func() {
+			defer close(purgeDone)
+			s.purgeEvery(purgeCtx, *purgeInterval)
+		}()
+	} else {
+		close(purgeDone)
+	}
+
+	server := newServer(s.routes())
+	// Event streams never finish on their own, so they're ended as soon as shutdown starts rather than holding it up
+	server.RegisterOnShutdown(events.close)
+	errc := make(chan error, 1)
+	go func() {
+		if *useTLS {
+			slog.Info("listening with TLS", "addr", *addr)
+			errc <- server.ListenAndServeTLS(*certFile, *keyFile)
+			return
+		}
+		slog.Info("listening", "addr", *addr, "h2c", *useH2C)
+		errc <- server.ListenAndServe()
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-errc:
+		// ListenAndServe only returns here if the server couldn't start, e.g. the port is already in use
+		fatal(err.Error())
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down")
+	// Whatever is still going after -shutdown-timeout is cut off, but the view counts and the store are still saved and closed below
+	if err := shutdown(server); err != nil {
+		fatal("shutting down", "err", err)
+	}
+	close(stopViews)
+	// The purge has to be finished with the store before it's closed
+	stopPurge()
+	<-purgeDone
+	if err := views.save(); err != nil {
+		slog.Error("saving view counts", "err", err)
+	}
+	if c, ok := s.store.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			slog.Error("closing store", "err", err)
+		}
+	}
+	slog.Info("shutdown complete")
+}
+
+// routes returns the handler for every page of the wiki, with all the middleware around it
+func (s *wikiServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	// route registers a handler on mux, recording metrics for it under name
+	route := func(pattern, name string, h http.Handler) {
+		mux.Handle(pattern, metricsMiddleware(name, h))
+	}
+	route("/", "root", http.HandlerFunc(s.rootHandler))
+	route("/pages", "index", gzipMiddleware(http.HandlerFunc(s.indexHandler)))
+	route("/search", "search", gzipMiddleware(http.HandlerFunc(s.searchHandler)))
+	route("/tags", "tags", http.HandlerFunc(s.tagsHandler))
+	route("/tags/", "tags", http.HandlerFunc(s.tagsHandler))
+	route("/recent", "recent", gzipMiddleware(http.HandlerFunc(s.recentHandler)))
+	route("/popular", "popular", http.HandlerFunc(s.popularHandler))
+	route("/random", "random", http.HandlerFunc(s.randomHandler))
+	route("/maintenance/brokenlinks", "brokenlinks", http.HandlerFunc(s.brokenLinksHandler))
+	route("/theme", "theme", http.HandlerFunc(themeHandler))
+	route("/lang", "lang", http.HandlerFunc(langHandler))
+	route("/export", "export", http.HandlerFunc(s.exportHandler))
+	route("/import", "import", readOnlyMiddleware(authMiddleware(http.HandlerFunc(s.importHandler))))
+	route("/view/", "view", gzipMiddleware(makeHandler(s.viewHandler)))
+	route("/events/", "events", http.HandlerFunc(eventsHandler))
+	route("/edit/", "edit", readOnlyMiddleware(authMiddleware(makeHandler(s.editHandler))))
+	route("/save/", "save", readOnlyMiddleware(authMiddleware(makeHandler(s.saveHandler))))
+	route("/delete/", "delete", readOnlyMiddleware(authMiddleware(makeHandler(s.deleteHandler))))
+	route("/history/", "history", makeHandler(s.historyHandler))
+	route("/diff/", "diff", makeHandler(s.diffHandler))
+	route("/rename/", "rename", readOnlyMiddleware(authMiddleware(makeHandler(s.renameHandler))))
+	route("/trash", "trash", http.HandlerFunc(s.trashHandler))
+	route("/restore/", "restore", readOnlyMiddleware(authMiddleware(makeHandler(s.restoreHandler))))
+	route("/highlight.css", "static", http.HandlerFunc(highlightCSSHandler))
+	route("/favicon.ico", "static", http.HandlerFunc(faviconHandler))
+	route("/sitemap.xml", "sitemap", gzipMiddleware(http.HandlerFunc(s.sitemapHandler)))
+	route("/static/", "static", http.StripPrefix("/static/", http.FileServer(noListingFS{http.FS(staticFS())})))
+	route("/draft/", "draft", readOnlyMiddleware(authMiddleware(makeHandler(draftHandler))))
+	route("/upload/", "upload", readOnlyMiddleware(authMiddleware(makeHandler(s.uploadHandler))))
+	route("/attachments/", "attachments", http.HandlerFunc(attachmentHandler))
+	route("/api/pages", "api_pages", http.HandlerFunc(s.apiPagesHandler))
+	route("/api/pages/", "api_page", http.HandlerFunc(s.apiPageHandler))
+	route("/api/search", "api_search", http.HandlerFunc(s.apiSearchHandler))
+	route("/api/backlinks/", "api_backlinks", http.HandlerFunc(s.apiBacklinksHandler))
+	route("/api/export", "api_export", http.HandlerFunc(s.apiExportHandler))
+	route("/admin/reindex", "admin_reindex", authMiddleware(http.HandlerFunc(s.reindexHandler)))
+
+	handler := timeoutMiddleware(concurrencyMiddleware(mux))
+	if *useTLS {
+		handler = hstsMiddleware(handler)
+	}
+	// The health checks and metrics are polled constantly by load balancers and Prometheus,
+	// so they are kept out of the request log and everything else wrapped around the main mux
+	root := http.NewServeMux()
+	root.HandleFunc("/healthz", healthzHandler)
+	root.HandleFunc("/readyz", s.readyzHandler)
+	root.Handle("/metrics", promhttp.Handler())
+	root.Handle("/", requestIDMiddleware(inFlight.middleware(loggingMiddleware(handler))))
+
+	return recoverMiddleware(securityHeaders(mountBasePath(root)))
 }
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	if err := loadTemplates(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// setFlag sets a flag's value for the length of a test, putting the old one back when it's done
+func setFlag[T any](t *testing.T, flag *T, v T) {
+	t.Helper()
+	old := *flag
+	*flag = v
+	t.Cleanup(func() { *flag = old })
+}
+
+// newTestWiki returns a wiki kept in a MemStore along with all its routes
+// The data directory, where attachments and the like go, is a temporary one
+func newTestWiki(t *testing.T) (*wikiServer, http.Handler) {
+	t.Helper()
+	setFlag(t, dataDir, t.TempDir())
+	s := newWikiServer(NewMemStore())
+	return s, s.routes()
+}
+
+// addPage saves a page straight to the wiki's store
+func addPage(t *testing.T, s *wikiServer, title, body string) {
+	t.Helper()
+	if err := s.savePage(context.Background(), &Page{Title: title, Body: []byte(body)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testSession is a CSRF cookie for requests that need to keep the same session from one to the next, like a browser would
+// The token is in every view, so without it each response would be different
+var testSession = &http.Cookie{Name: csrfCookie, Value: "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"}
+
+// postForm posts form to path the way the edit form does, with the CSRF token for testSession
+func postForm(h http.Handler, path string, form url.Values) *httptest.ResponseRecorder {
+	form.Set(csrfField, testSession.Value)
+	r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(testSession)
+	return serve(h, r)
+}
+
+// savePageForm saves a page through /save/ like the edit form does
+func savePageForm(h http.Handler, title, body string) *httptest.ResponseRecorder {
+	return postForm(h, "/save/"+title, url.Values{"body": {body}})
+}
+
+// serve sends r to h and returns the response
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// jsonRequest is a request to the API with body as its JSON
+func jsonRequest(method, path, body string) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+// Titles that would reach outside the data directory are turned away before they get near the disk
+func TestTraversalTitles(t *testing.T) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	s := newWikiServer(NewFileStore(data))
+	ctx := context.Background()
+	for _, title := range []string{"../../etc/passwd", "..", "../escape", "a/../../escape", "/etc/passwd", "a//b", "", `a\b`, "a.txt", "history/Page"} {
+		if err := validateTitle(title); err == nil {
+			t.Errorf("validateTitle(%q) accepted it", title)
+		}
+		if _, err := s.loadPage(ctx, title); err == nil {
+			t.Errorf("loadPage(%q) loaded something", title)
+		}
+		if err := s.savePage(ctx, &Page{Title: title, Body: []byte("escaped")}); err == nil {
+			t.Errorf("savePage(%q) saved it", title)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escape.txt")); err == nil {
+		t.Error("a page was written outside the data directory")
+	}
+}
+
+// Paths that aren't anything get the wiki's own 404 page rather than net/http's plain text one
+func TestNotFoundPage(t *testing.T) {
+	_, h := newTestWiki(t)
+	for _, path := range []string{"/nonexistent", "/view/not.a.title"} {
+		w := serve(h, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("GET %s got %d, want 404", path, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("GET %s has Content-Type %q, want the HTML template", path, ct)
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, "<h1>Page not found</h1>") || !strings.Contains(body, "<code>"+path+"</code>") {
+			t.Errorf("GET %s isn't the 404 template: %s", path, body)
+		}
+	}
+}
+
+// The main view, edit, save and delete cycle, all against a MemStore
+func TestPageHandlers(t *testing.T) {
+	s, h := newTestWiki(t)
+	ctx := t.Context()
+
+	w := serve(h, httptest.NewRequest("GET", "/view/Fresh", nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/edit/Fresh" {
+		t.Fatalf("viewing a missing page got %d to %q, want a redirect to its edit form", w.Code, w.Header().Get("Location"))
+	}
+	w = serve(h, httptest.NewRequest("GET", "/edit/Fresh", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<textarea name="body"`) {
+		t.Fatalf("edit form got %d", w.Code)
+	}
+
+	if w := savePageForm(h, "Fresh", "# Fresh\n\nsome *text*"); w.Code != http.StatusFound || w.Header().Get("Location") != "/view/Fresh" {
+		t.Fatalf("save got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	p, err := s.store.Load(ctx, "Fresh")
+	if err != nil || string(p.Body) != "# Fresh\n\nsome *text*" {
+		t.Fatalf("stored page is %v, %v", p, err)
+	}
+	w = serve(h, httptest.NewRequest("GET", "/view/Fresh", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "some <em>text</em>") {
+		t.Errorf("view got %d: %s", w.Code, w.Body)
+	}
+	w = serve(h, httptest.NewRequest("GET", "/pages", nil))
+	if !strings.Contains(w.Body.String(), `href="/view/Fresh"`) {
+		t.Errorf("index doesn't list the page: %s", w.Body)
+	}
+
+	if w := postForm(h, "/delete/Fresh", url.Values{}); w.Code != http.StatusFound {
+		t.Fatalf("delete got %d", w.Code)
+	}
+	if _, err := s.store.Load(ctx, "Fresh"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("after the delete Load returned %v", err)
+	}
+}
+
+// unreadableStore is a store whose pages all exist but can't be read
+type unreadableStore struct{ Store }
+
+func (unreadableStore) Load(ctx context.Context, title string) (*Page, error) {
+	return nil, &os.PathError{Op: "open", Path: title + ".txt", Err: os.ErrPermission}
+}
+
+// Only a page that isn't there is sent to the edit form, any other error loading it is a 500
+func TestViewLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	setFlag(t, dataDir, dir)
+	h := newWikiServer(NewFileStore(dir)).routes()
+
+	w := serve(h, httptest.NewRequest("GET", "/view/Missing", nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/edit/Missing" {
+		t.Errorf("missing page got %d to %q, want a redirect to the edit form", w.Code, w.Header().Get("Location"))
+	}
+
+	w = serve(newWikiServer(unreadableStore{NewMemStore()}).routes(), httptest.NewRequest("GET", "/view/Locked", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("store permission error got %d, want 500", w.Code)
+	}
+
+	// Root can read the file whatever its permissions say
+	if os.Geteuid() == 0 {
+		t.Skip("running as root, so files can't be made unreadable")
+	}
+	filename := filepath.Join(dir, "Locked.txt")
+	if err := os.WriteFile(filename, []byte("secret"), 0); err != nil {
+		t.Fatal(err)
+	}
+	w = serve(h, httptest.NewRequest("GET", "/view/Locked", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("unreadable file got %d, want 500", w.Code)
+	}
+}
+
+// Scripts and event handlers saved in a page never make it to the browser, whichever format the page is in
+func TestRenderBodySanitizes(t *testing.T) {
+	s := newWikiServer(NewMemStore())
+	attack := "<script>alert(1)</script>\n\n<img src=\"x.png\" onerror=\"alert(2)\">\n\n"
+	tests := []struct{ name, body, keep string }{
+		{"markdown", attack + "![picture](y.png)\n", `<img src="y.png" alt="picture"`},
+		{"html", "---\nformat: html\n---\n" + attack, `<img src="x.png"`},
+	}
+	for _, tt := range tests {
+		p := &Page{Title: "Attack", Body: []byte(tt.body)}
+		p.parseFrontMatter()
+		html, err := s.renderBody(context.Background(), p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, bad := range []string{"<script", "alert(1)", "onerror", "alert(2)"} {
+			if strings.Contains(string(html), bad) {
+				t.Errorf("%s: %q was left in %s", tt.name, bad, html)
+			}
+		}
+		if !strings.Contains(string(html), tt.keep) {
+			t.Errorf("%s: %s is missing %s", tt.name, html, tt.keep)
+		}
+	}
+}
+
+// Titles over -maxtitle are turned away before they get anywhere near a filename
+func TestTitleTooLong(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	if err := validateTitle(long); !errors.Is(err, errTitleTooLong) {
+		t.Errorf("validateTitle of 300 characters returned %v", err)
+	}
+	if err := validateTitle(strings.Repeat("a", *maxTitle)); err != nil {
+		t.Errorf("validateTitle of exactly -maxtitle characters returned %v", err)
+	}
+
+	_, h := newTestWiki(t)
+	for _, path := range []string{"/view/", "/edit/"} {
+		w := serve(h, httptest.NewRequest("GET", path+long, nil))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), titleTooLongMessage()) {
+			t.Errorf("GET %s got %d: %s", path, w.Code, w.Body)
+		}
+	}
+	if w := savePageForm(h, long, "body"); w.Code != http.StatusBadRequest {
+		t.Errorf("save got %d: %s", w.Code, w.Body)
+	}
+}
+
+// A trailing slash is redirected away, with a 308 for anything but GET and HEAD so the method and body survive
+func TestTrailingSlash(t *testing.T) {
+	s, h := newTestWiki(t)
+	addPage(t, s, "Home", "home")
+
+	tests := []struct {
+		method, path string
+		code         int
+		location     string
+	}{
+		{"GET", "/view/Home/", http.StatusMovedPermanently, "/view/Home"},
+		{"HEAD", "/view/Home/", http.StatusMovedPermanently, "/view/Home"},
+		{"GET", "/view/Home/?raw=1", http.StatusMovedPermanently, "/view/Home?raw=1"},
+		{"POST", "/save/Home/", http.StatusPermanentRedirect, "/save/Home"},
+		{"GET", "/view/Home", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		w := serve(h, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.code || w.Header().Get("Location") != tt.location {
+			t.Errorf("%s %s got %d to %q, want %d to %q", tt.method, tt.path, w.Code, w.Header().Get("Location"), tt.code, tt.location)
+		}
+	}
+}
+
+// -maxpages stops new pages being added once it's reached, but the pages already there can still be changed
+func TestMaxPages(t *testing.T) {
+	setFlag(t, maxPages, 3)
+	setFlag(t, saveRate, 0)
+	_, h := newTestWiki(t)
+
+	for _, title := range []string{"One", "Two", "Three"} {
+		if w := savePageForm(h, title, "body"); w.Code != http.StatusFound {
+			t.Fatalf("saving %s got %d: %s", title, w.Code, w.Body)
+		}
+	}
+	if w := savePageForm(h, "Four", "body"); w.Code != http.StatusInsufficientStorage {
+		t.Errorf("saving a page over the limit got %d, want 507", w.Code)
+	}
+	w := serve(h, jsonRequest("PUT", "/api/pages/Four", `{"body": "body"}`))
+	if w.Code != http.StatusInsufficientStorage {
+		t.Errorf("API save of a page over the limit got %d, want 507", w.Code)
+	}
+	if w := savePageForm(h, "Two", "changed"); w.Code != http.StatusFound {
+		t.Errorf("updating a page at the limit got %d: %s", w.Code, w.Body)
+	}
+	if w := serve(h, httptest.NewRequest("GET", "/view/Four", nil)); w.Code != http.StatusFound {
+		t.Errorf("the refused page exists, view got %d", w.Code)
+	}
+}
+
+// With -ignorecase a title in the wrong case finds the page, for HEAD the same as for GET, and an exact match always wins
+func TestIgnoreCase(t *testing.T) {
+	s, h := newTestWiki(t)
+	addPage(t, s, "HomePage", "home")
+	addPage(t, s, "Exact", "upper")
+	addPage(t, s, "exact", "lower")
+	addPage(t, s, "Alias", "#REDIRECT [homepage]")
+
+	tests := []struct {
+		path       string
+		ignoreCase bool
+		code       int
+		location   string
+	}{
+		{"/view/homepage", true, http.StatusMovedPermanently, "/view/HomePage"},
+		{"/view/HomePage", true, http.StatusOK, ""},
+		{"/view/exact", true, http.StatusOK, ""},
+		{"/view/Alias", true, http.StatusFound, "/view/homepage?from=Alias"},
+		{"/view/nopage", true, http.StatusFound, "/edit/nopage"},
+		{"/view/homepage", false, http.StatusFound, "/edit/homepage"},
+	}
+	for _, tt := range tests {
+		setFlag(t, ignoreCase, tt.ignoreCase)
+		for _, method := range []string{"GET", "HEAD"} {
+			code, location := tt.code, tt.location
+			// HEAD asks whether the page is there, so it's told it isn't rather than sent to the edit form
+			if method == "HEAD" && strings.HasPrefix(location, "/edit/") {
+				code, location = http.StatusNotFound, ""
+			}
+			w := serve(h, httptest.NewRequest(method, tt.path, nil))
+			if w.Code != code || w.Header().Get("Location") != location {
+				t.Errorf("%s %s with -ignorecase=%t got %d to %q, want %d to %q",
+					method, tt.path, tt.ignoreCase, w.Code, w.Header().Get("Location"), code, location)
+			}
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	"golang.org/x/net/html"
+)
+
+// wikiLink matches links to other pages written as [PageName]
+var wikiLink = regexp.MustCompile(`\[(` + titlePattern + `)\]`)
+
+// wikiLinkStart is wikiLink anchored to where goldmark has found a [
+var wikiLinkStart = regexp.MustCompile(`^\[(` + titlePattern + `)\]`)
+
+// A WikiLink is a [PageName] link in a Markdown page
+// Its only child is the title's text, so the title still shows up wherever goldmark
+// uses a node's text, like the alt of an image. Missing is set for pages that don't exist yet
+type WikiLink struct {
+	ast.BaseInline
+	Title   string
+	Missing bool
+}
+
+// KindWikiLink is the ast.NodeKind of a WikiLink
+var KindWikiLink = ast.NewNodeKind("WikiLink")
+
+// Kind implements ast.Node
+func (n *WikiLink) Kind() ast.NodeKind {
+	return KindWikiLink
+}
+
+// Dump implements ast.Node
+func (n *WikiLink) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Title": n.Title}, nil)
+}
+
+// wikiLinks is the goldmark extension that turns [PageName] into links while Markdown is parsed
+// Doing it in the parser rather than on the finished HTML means brackets in code, attributes and
+// the like are left alone, as goldmark never asks wikiLinkParser about them
+var wikiLinks wikiLinkExtension
+
+type wikiLinkExtension struct{}
+
+// Extend implements goldmark.Extender
+// wikiLinkParser goes ahead of goldmark's own link parser, which also starts at [
+func (wikiLinkExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithInlineParsers(util.Prioritized(wikiLinkParser{}, 150)),
+		parser.WithASTTransformers(util.Prioritized(wikiLinkTransformer{}, 100)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(wikiLinkRenderer{}, 100)))
+}
+
+// pageExistsKey is where renderMarkdown puts the function wikiLinkTransformer checks link targets with
+var pageExistsKey = parser.NewContextKey()
+
+type wikiLinkParser struct{}
+
+// Trigger implements parser.InlineParser
+func (wikiLinkParser) Trigger() []byte {
+	return []byte{'['}
+}
+
+// Parse implements parser.InlineParser
+// [PageName](url) and [PageName][ref] are ordinary Markdown links, and so is a bare [PageName]
+// with a [PageName]: url reference defined for it, so those are left to goldmark
+func (wikiLinkParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, seg := block.PeekLine()
+	m := wikiLinkStart.FindSubmatchIndex(line)
+	if m == nil {
+		return nil
+	}
+	if m[1] < len(line) && (line[m[1]] == '(' || line[m[1]] == '[') {
+		return nil
+	}
+	title := line[m[2]:m[3]]
+	if _, ok := pc.Reference(util.ToLinkReference(title)); ok {
+		return nil
+	}
+	block.Advance(m[1])
+	n := &WikiLink{Title: string(title)}
+	n.AppendChild(n, ast.NewTextSegment(text.NewSegment(seg.Start+m[2], seg.Start+m[3])))
+	return n
+}
+
+type wikiLinkTransformer struct{}
+
+// Transform implements parser.ASTTransformer
+// A [PageName] in the text of another link can't be a link itself, so it's turned back into just its title.
+// The others are marked Missing if the page doesn't exist, when there's a pageExistsKey to ask
+func (wikiLinkTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	exists, _ := pc.Get(pageExistsKey).(func(title string) bool)
+	var nested []*WikiLink
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		l, ok := n.(*WikiLink)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		if insideLink(l) {
+			nested = append(nested, l)
+		} else if exists != nil {
+			l.Missing = !exists(l.Title)
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	for _, l := range nested {
+		l.Parent().ReplaceChild(l.Parent(), l, l.FirstChild())
+	}
+}
+
+// insideLink reports whether n is somewhere in the text of a link
+func insideLink(n ast.Node) bool {
+	for p := n.Parent(); p != nil; p = p.Parent() {
+		if p.Kind() == ast.KindLink || p.Kind() == ast.KindAutoLink {
+			return true
+		}
+	}
+	return false
+}
+
+type wikiLinkRenderer struct{}
+
+// RegisterFuncs implements renderer.NodeRenderer
+func (wikiLinkRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindWikiLink, func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			l := n.(*WikiLink)
+			w.WriteString(wikiLinkTag(l.Title, l.Missing))
+		} else {
+			w.WriteString("</a>")
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// wikiLinkTag returns the opening tag of a link to the page with the given title
+// Links to pages that don't exist yet get the missing class so broken links stand out.
+// The titles can only be alphanumeric, so they're safe to put in the tag as is
+func wikiLinkTag(title string, missing bool) string {
+	class := ""
+	if missing {
+		class = ` class="missing"`
+	}
+	return `<a href="` + pathTo("/view/"+title) + `"` + class + `>`
+}
+
+// noWikiLinks are the elements of an HTML page whose text is never turned into links,
+// either because a link can't go there or because the text is meant to be shown as it is
+var noWikiLinks = map[string]bool{
+	"a": true, "code": true, "pre": true, "kbd": true, "samp": true,
+	"script": true, "style": true, "textarea": true, "title": true,
+}
+
+// replaceHTMLWikiLinks calls repl with every [PageName] in the text of an HTML page and puts back what it returns
+// Only text goes to repl, never tags or attributes, and not the text inside noWikiLinks elements.
+// Everything else is written back exactly as it was
+func replaceHTMLWikiLinks(body []byte, repl func(m []byte) []byte) []byte {
+	z := html.NewTokenizer(bytes.NewReader(body))
+	var out bytes.Buffer
+	skip := 0
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			// Either the end of the page, or something the tokenizer gave up on, which is passed on to the sanitizer as it is
+			out.Write(z.Raw())
+			return out.Bytes()
+		}
+		if tt == html.TextToken && skip == 0 {
+			out.Write(wikiLink.ReplaceAllFunc(z.Raw(), repl))
+			continue
+		}
+		// TagName lowercases the name in place, so the tag is written out first
+		out.Write(z.Raw())
+		if tt != html.StartTagToken && tt != html.EndTagToken {
+			continue
+		}
+		name, _ := z.TagName()
+		if !noWikiLinks[string(name)] {
+			continue
+		}
+		if tt == html.StartTagToken {
+			skip++
+		} else if skip > 0 {
+			skip--
+		}
+	}
+}
+
+// linkifyHTML turns every [PageName] in the text of an HTML page into a link, the way wikiLinks does for Markdown
+func linkifyHTML(body []byte, exists func(title string) bool) []byte {
+	return replaceHTMLWikiLinks(body, func(m []byte) []byte {
+		title := string(m[1 : len(m)-1])
+		return []byte(wikiLinkTag(title, !exists(title)) + title + "</a>")
+	})
+}
+
+// linkedTitles returns the title of every page p links to with [PageName], in the order they appear
+// The page is parsed the same way it's rendered, so these are exactly the links shown on it
+func linkedTitles(p *Page) []string {
+	var titles []string
+	if p.Format == formatHTML {
+		replaceHTMLWikiLinks(p.Content(), func(m []byte) []byte {
+			titles = append(titles, string(m[1:len(m)-1]))
+			return m
+		})
+		return titles
+	}
+	doc := markdown.Parser().Parse(text.NewReader(p.Content()))
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if l, ok := n.(*WikiLink); ok && entering {
+			titles = append(titles, l.Title)
+		}
+		return ast.WalkContinue, nil
+	})
+	return titles
+}
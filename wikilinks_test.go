@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRenderWikiLinks(t *testing.T) {
+	s := newWikiServer(NewMemStore())
+	if err := s.store.Save(context.Background(), &Page{Title: "Exists", Body: []byte("here")}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, body   string
+		want, absent []string
+	}{
+		{
+			name: "existing page",
+			body: "see [Exists]",
+			want: []string{`<a href="/view/Exists">Exists</a>`},
+		},
+		{
+			name: "missing page",
+			body: "see [Nowhere]",
+			want: []string{`<a href="/view/Nowhere" class="missing">Nowhere</a>`},
+		},
+		{
+			name:   "code span",
+			body:   "`[Exists]`",
+			want:   []string{"<code>[Exists]</code>"},
+			absent: []string{"<a "},
+		},
+		{
+			name:   "code block",
+			body:   "```\n[Exists]\n```",
+			want:   []string{"[Exists]"},
+			absent: []string{"<a "},
+		},
+		{
+			name:   "image alt",
+			body:   "![a [Exists] picture](/pic.png)",
+			want:   []string{`alt="a Exists picture"`},
+			absent: []string{"<a "},
+		},
+		{
+			name:   "inside a link",
+			body:   "[about [Exists]](https://example.com)",
+			want:   []string{`<a href="https://example.com" rel="nofollow">about Exists</a>`},
+			absent: []string{"/view/Exists"},
+		},
+		{
+			name: "markdown link",
+			body: "[Exists](https://example.com)",
+			want: []string{`<a href="https://example.com" rel="nofollow">Exists</a>`},
+		},
+		{
+			name:   "reference link",
+			body:   "[Exists]\n\n[Exists]: https://example.com",
+			want:   []string{`<a href="https://example.com" rel="nofollow">Exists</a>`},
+			absent: []string{"/view/Exists"},
+		},
+		{
+			name: "html page",
+			body: "---\nformat: html\n---\n<p>see [Exists] and [Nowhere]</p>",
+			want: []string{`<a href="/view/Exists">Exists</a>`, `<a href="/view/Nowhere" class="missing">Nowhere</a>`},
+		},
+		{
+			name:   "html attribute",
+			body:   "---\nformat: html\n---\n<img src=\"/pic.png\" alt=\"[Exists]\"><code>[Exists]</code>",
+			want:   []string{`alt="[Exists]"`, "<code>[Exists]</code>"},
+			absent: []string{"<a "},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Page{Title: "Test", Body: []byte(tt.body)}
+			p.parseFrontMatter()
+			html, err := s.renderBody(context.Background(), p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(html), want) {
+					t.Errorf("rendered %q, want it to contain %q", html, want)
+				}
+			}
+			for _, absent := range tt.absent {
+				if strings.Contains(string(html), absent) {
+					t.Errorf("rendered %q, want no %q in it", html, absent)
+				}
+			}
+		})
+	}
+}
+
+func TestLinkedTitles(t *testing.T) {
+	p := &Page{Title: "Test", Body: []byte("[One] `[Two]` [Three](/x)\n\n    [Four]\n\n[Five]")}
+	p.parseFrontMatter()
+	if got := strings.Join(linkedTitles(p), " "); got != "One Five" {
+		t.Errorf("linkedTitles = %q, want %q", got, "One Five")
+	}
+}